@@ -0,0 +1,472 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khouwdevin/gitomatically/watcher"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+type DeployRequest struct {
+	Branch   string `json:"branch"`
+	Tag      string `json:"tag"`
+	Sha      string `json:"sha"`
+	SkipPull bool   `json:"skip_pull"`
+	Force    bool   `json:"force"`
+}
+
+var shaPattern = regexp.MustCompile("^[0-9a-f]{4,40}$")
+
+func APIAuthorization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := os.Getenv("API_TOKEN")
+
+		if secret == "" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": "API_TOKEN is not configured!"})
+			c.Abort()
+
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+		if !ok || token == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Authorization is not found!"})
+			c.Abort()
+
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			slog.Debug(fmt.Sprintf("MIDDLEWARE Invalid API token from %v", c.ClientIP()))
+
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized!"})
+			c.Abort()
+
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// QueryTokenAuthorization accepts the API token from the token query parameter, browsers cannot set headers on an
+// EventSource
+func QueryTokenAuthorization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+		}
+
+		c.Next()
+	}
+}
+
+// LogFormatter is the request log format of gin with the token query parameter redacted, so the API token of the
+// deployment stream is not written to the logs
+func LogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		RedactToken(param.Path),
+		param.ErrorMessage,
+	)
+}
+
+// RedactToken replaces the value of the token query parameter of the path
+func RedactToken(path string) string {
+	base, rawQuery, ok := strings.Cut(path, "?")
+
+	if !ok {
+		return path
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+
+	if err != nil {
+		return base
+	}
+
+	if !query.Has("token") {
+		return path
+	}
+
+	query.Set("token", "redacted")
+
+	return fmt.Sprintf("%v?%v", base, query.Encode())
+}
+
+// deployments returns the recorded deployments from the newest to the oldest
+func deployments() ([]Job, error) {
+	if History == nil {
+		return nil, nil
+	}
+
+	jobs, err := History.List()
+
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(jobs)
+
+	return jobs, nil
+}
+
+func RepositoriesController(c *gin.Context) {
+	jobs, err := deployments()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("API Read history error %v", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	var names []string
+
+	for name := range Settings.Repositories {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	repositories := []gin.H{}
+
+	for _, name := range names {
+		repository := Settings.Repositories[name]
+
+		item := gin.H{
+			"name":      name,
+			"provider":  repository.Provider,
+			"url":       repository.Url,
+			"path":      repository.Path,
+			"deploy_on": repository.DeployOn,
+		}
+
+		if headRef, err := GitHead(repository.Path); err == nil {
+			item["sha"] = headRef.Hash().String()
+
+			if headRef.Name().IsBranch() {
+				item["branch"] = headRef.Name().Short()
+			}
+		}
+
+		index := slices.IndexFunc(jobs, func(job Job) bool {
+			return job.Repository == name && job.Status != JobSuperseded
+		})
+
+		if index != -1 {
+			item["last_deployment"] = gin.H{
+				"id":          jobs[index].Id,
+				"status":      jobs[index].Status,
+				"sha":         jobs[index].Sha,
+				"started_at":  jobs[index].StartedAt,
+				"finished_at": jobs[index].FinishedAt,
+			}
+		}
+
+		repositories = append(repositories, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"repositories": repositories})
+}
+
+func DeploymentsController(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "page must be a positive number"})
+		return
+	}
+
+	perPage, err := strconv.Atoi(c.DefaultQuery("per_page", strconv.Itoa(defaultPerPage)))
+
+	if err != nil || perPage < 1 || perPage > maxPerPage {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("per_page must be between 1 and %v", maxPerPage)})
+		return
+	}
+
+	jobs, err := deployments()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("API Read history error %v", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	repository := c.Query("repo")
+	status := c.Query("status")
+
+	jobs = slices.DeleteFunc(jobs, func(job Job) bool {
+		return (repository != "" && job.Repository != repository) || (status != "" && string(job.Status) != status)
+	})
+
+	total := len(jobs)
+	start := min((page-1)*perPage, total)
+	end := min(start+perPage, total)
+
+	// The list only contains the summary, the command output is returned by the deployment endpoint
+	items := []Job{}
+
+	for _, job := range jobs[start:end] {
+		job.Commands = nil
+		items = append(items, job)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployments": items,
+		"page":        page,
+		"per_page":    perPage,
+		"total":       total,
+	})
+}
+
+func DeploymentController(c *gin.Context) {
+	id := c.Param("id")
+
+	// The queue has the output of the commands of a running job, the history only has it once the job finished
+	if job, ok := GetJob(id); ok {
+		c.JSON(http.StatusOK, job)
+		return
+	}
+
+	jobs, err := deployments()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("API Read history error %v", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	index := slices.IndexFunc(jobs, func(job Job) bool { return job.Id == id })
+
+	if index != -1 {
+		c.JSON(http.StatusOK, jobs[index])
+		return
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"message": "Deployment is not found!"})
+}
+
+func DeployController(c *gin.Context) {
+	if watcher.GetSettingStatus() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "Settings are changing, try again later"})
+		return
+	}
+
+	name := c.Param("name")
+
+	repository, ok := Settings.Repositories[name]
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Repository is not found!"})
+		return
+	}
+
+	repository.Name = name
+
+	var request DeployRequest
+
+	// The body is optional, an empty body deploys the default branch
+	if c.Request.ContentLength != 0 {
+		err := c.ShouldBindJSON(&request)
+
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid payload"})
+			return
+		}
+	}
+
+	if request.Sha != "" && !shaPattern.MatchString(request.Sha) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "sha must be a hexadecimal commit hash"})
+		return
+	}
+
+	event := PushEvent{Provider: repository.Provider, RepositoryUrl: repository.Url}
+
+	if repository.DeploysTags() {
+		// Without pull the commands are rerun on the tag that is checked out
+		if !request.SkipPull {
+			if request.Tag == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "tag is required unless skip_pull is set"})
+				return
+			}
+
+			event.Tag = request.Tag
+			event.Ref = fmt.Sprintf("refs/tags/%v", request.Tag)
+		}
+	} else {
+		branch := request.Branch
+
+		if branch == "" {
+			expanded := repository.Expand()
+
+			if len(expanded) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "branch is required, the repository only has branch patterns"})
+				return
+			}
+
+			branch = expanded[0].Branch
+		}
+
+		branchRepository, ok := repository.ForBranch(branch)
+
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("%v branch is not configured", branch)})
+			return
+		}
+
+		repository = branchRepository
+		event.Branch = branch
+		event.Ref = fmt.Sprintf("refs/heads/%v", branch)
+	}
+
+	job := NewJob(repository, event, "manual", DeployOptions{Sha: request.Sha, Force: request.Force, SkipPull: request.SkipPull})
+
+	err := Enqueue(job)
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("API Enqueue job of %v error %v", name, err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "Deployment queue is not running"})
+		return
+	}
+
+	slog.Info(fmt.Sprintf("API Manual deployment %v of %v is queued", job.Id, name))
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Deployment is queued", "job": job.Id})
+}
+
+// streamKeepAlive is how often a comment is sent to keep idle streams open through proxies
+const streamKeepAlive = 15 * time.Second
+
+func DeploymentStreamController(c *gin.Context) {
+	id := c.Param("id")
+
+	job, ok := GetJob(id)
+
+	if !ok || job.Logs() == nil {
+		jobs, err := deployments()
+
+		if err != nil {
+			slog.Error(fmt.Sprintf("API Read history error %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		index := slices.IndexFunc(jobs, func(job Job) bool { return job.Id == id })
+
+		if index == -1 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Deployment is not found!"})
+			return
+		}
+
+		// Older deployments are not in memory anymore, their recorded output is sent at once
+		startStream(c)
+
+		position := 0
+
+		for _, command := range jobs[index].Commands {
+			writeLogEvent(c, position, LogLine{Time: command.StartedAt, Stream: "system", Text: fmt.Sprintf("$ %v", command.Command)})
+			position++
+
+			for _, text := range strings.Split(strings.TrimRight(command.Output, "\n"), "\n") {
+				writeLogEvent(c, position, LogLine{Time: command.StartedAt, Stream: "output", Text: text})
+				position++
+			}
+		}
+
+		c.SSEvent("end", gin.H{"status": jobs[index].Status})
+		c.Writer.Flush()
+
+		return
+	}
+
+	startStream(c)
+
+	// Reconnecting EventSources send the id of the last event, so they continue where they stopped
+	position, err := strconv.Atoi(c.GetHeader("Last-Event-ID"))
+
+	if err != nil {
+		position = 0
+	} else {
+		position++
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		lines, next, wait, closed := job.Logs().Since(position)
+
+		for i, line := range lines {
+			writeLogEvent(c, next-len(lines)+i, line)
+		}
+
+		position = next
+
+		c.Writer.Flush()
+
+		if closed {
+			job, _ = GetJob(id)
+
+			c.SSEvent("end", gin.H{"status": job.Status})
+			c.Writer.Flush()
+
+			return
+		}
+
+		select {
+		case <-wait:
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep alive\n\n")
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func startStream(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Status(http.StatusOK)
+}
+
+func writeLogEvent(c *gin.Context, position int, line LogLine) {
+	data, _ := json.Marshal(line)
+
+	fmt.Fprintf(c.Writer, "id: %v\nevent: log\ndata: %s\n\n", position, data)
+}
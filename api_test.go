@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func sendAPIRequest(t *testing.T, method string, url string, token string, body string) (*httptest.ResponseRecorder, map[string]any) {
+	router := gin.New()
+
+	api := router.Group("/api", APIAuthorization())
+	api.GET("/repositories", RepositoriesController)
+	api.GET("/deployments", DeploymentsController)
+	api.GET("/deployments/:id", DeploymentController)
+	api.POST("/repositories/:name/deploy", DeployController)
+
+	router.GET("/api/deployments/:id/stream", QueryTokenAuthorization(), APIAuthorization(), DeploymentStreamController)
+
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+	}
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	var jsonResponse map[string]any
+
+	// Streams are not JSON
+	if res.Header().Get("Content-Type") == "text/event-stream" {
+		return res, jsonResponse
+	}
+
+	err := json.Unmarshal(res.Body.Bytes(), &jsonResponse)
+
+	if err != nil {
+		t.Errorf("Failed to unmarshall response %v", err)
+	}
+
+	return res, jsonResponse
+}
+
+func apiTestHistory(t *testing.T) {
+	journal, err := NewJournal(filepath.Join(t.TempDir(), "deployments.jsonl"), 100)
+
+	if err != nil {
+		t.Fatalf("New journal error %v", err)
+	}
+
+	History = journal
+
+	t.Cleanup(func() {
+		History = nil
+	})
+
+	for i := range 5 {
+		status := JobSucceeded
+
+		if i%2 == 1 {
+			status = JobFailed
+		}
+
+		journal.Append(Job{
+			Id:         fmt.Sprintf("job-%v", i),
+			Repository: "gitomatically",
+			Status:     status,
+			Sha:        fmt.Sprintf("sha-%v", i),
+			Commands:   []CommandResult{{Command: "docker compose up -d", Output: "started"}},
+		})
+	}
+
+	journal.Append(Job{Id: "other", Repository: "example.com", Status: JobSucceeded})
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	res, jsonResponse := sendAPIRequest(t, "GET", "/api/deployments", "helloworld", "")
+
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code, "API should be disabled without a token")
+	assert.Equal(t, "API_TOKEN is not configured!", jsonResponse["message"])
+
+	t.Setenv("API_TOKEN", "helloworld")
+
+	res, jsonResponse = sendAPIRequest(t, "GET", "/api/deployments", "", "")
+
+	assert.Equal(t, http.StatusUnauthorized, res.Code, "API should require a token")
+	assert.Equal(t, "Authorization is not found!", jsonResponse["message"])
+
+	res, jsonResponse = sendAPIRequest(t, "GET", "/api/deployments", "wrong", "")
+
+	assert.Equal(t, http.StatusUnauthorized, res.Code, "API should reject a wrong token")
+	assert.Equal(t, "Unauthorized!", jsonResponse["message"])
+
+	res, _ = sendAPIRequest(t, "GET", "/api/deployments", "helloworld", "")
+
+	assert.Equal(t, http.StatusOK, res.Code, "API should accept the token")
+}
+
+func TestAPIRepositories(t *testing.T) {
+	t.Setenv("API_TOKEN", "helloworld")
+
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	Settings.Repositories = map[string]RepositoryConfig{"gitomatically": repository}
+
+	apiTestHistory(t)
+
+	res, jsonResponse := sendAPIRequest(t, "GET", "/api/repositories", "helloworld", "")
+
+	assert.Equal(t, http.StatusOK, res.Code, "Repositories should return 200")
+
+	repositories := jsonResponse["repositories"].([]any)
+	item := repositories[0].(map[string]any)
+
+	assert.Len(t, repositories, 1, "Every configured repository should be listed")
+	assert.Equal(t, "gitomatically", item["name"])
+	assert.Equal(t, "master", item["branch"], "Current branch should be returned")
+	assert.Equal(t, headHash(t, repository.Path).String(), item["sha"], "Current commit should be returned")
+	assert.Equal(t, "job-4", item["last_deployment"].(map[string]any)["id"], "Last deployment should be returned")
+}
+
+func TestAPIDeployments(t *testing.T) {
+	t.Setenv("API_TOKEN", "helloworld")
+
+	apiTestHistory(t)
+
+	res, jsonResponse := sendAPIRequest(t, "GET", "/api/deployments?repo=gitomatically&per_page=2&page=2", "helloworld", "")
+
+	assert.Equal(t, http.StatusOK, res.Code, "Deployments should return 200")
+	assert.Equal(t, float64(5), jsonResponse["total"], "Total should count the filtered deployments")
+
+	deployments := jsonResponse["deployments"].([]any)
+
+	assert.Len(t, deployments, 2, "Page should contain per_page deployments")
+	assert.Equal(t, "job-2", deployments[0].(map[string]any)["id"], "Deployments should be sorted from the newest")
+	assert.Nil(t, deployments[0].(map[string]any)["commands"], "List should not contain the command output")
+
+	_, jsonResponse = sendAPIRequest(t, "GET", "/api/deployments?status=failed", "helloworld", "")
+
+	assert.Equal(t, float64(2), jsonResponse["total"], "Deployments should be filtered by status")
+
+	res, _ = sendAPIRequest(t, "GET", "/api/deployments?per_page=1000", "helloworld", "")
+
+	assert.Equal(t, http.StatusBadRequest, res.Code, "Too many deployments per page should be rejected")
+}
+
+func TestAPIDeployment(t *testing.T) {
+	t.Setenv("API_TOKEN", "helloworld")
+
+	apiTestHistory(t)
+
+	res, jsonResponse := sendAPIRequest(t, "GET", "/api/deployments/job-1", "helloworld", "")
+
+	assert.Equal(t, http.StatusOK, res.Code, "Deployment should return 200")
+	assert.Equal(t, "failed", jsonResponse["status"])
+	assert.Equal(t, "started", jsonResponse["commands"].([]any)[0].(map[string]any)["output"], "Deployment should contain the command output")
+
+	res, jsonResponse = sendAPIRequest(t, "GET", "/api/deployments/unknown", "helloworld", "")
+
+	assert.Equal(t, http.StatusNotFound, res.Code, "Unknown deployment should return 404")
+	assert.Equal(t, "Deployment is not found!", jsonResponse["message"])
+}
+
+func TestAPIRunningDeployment(t *testing.T) {
+	t.Setenv("API_TOKEN", "helloworld")
+
+	apiTestHistory(t)
+
+	job := NewJob(RepositoryConfig{Name: "gitomatically"}, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+	job.Status = JobRunning
+
+	// The running state is recorded before the first command
+	RecordJob(job)
+
+	job.AddCommand(CommandResult{Command: "docker compose up -d", Output: "building"})
+
+	queueMutex.Lock()
+	Jobs = NewQueue(0)
+	Jobs.jobs[job.Id] = job
+	queueMutex.Unlock()
+
+	t.Cleanup(func() {
+		queueMutex.Lock()
+		Jobs = nil
+		queueMutex.Unlock()
+	})
+
+	res, jsonResponse := sendAPIRequest(t, "GET", fmt.Sprintf("/api/deployments/%v", job.Id), "helloworld", "")
+
+	assert.Equal(t, http.StatusOK, res.Code, "Running deployment should return 200")
+	assert.Equal(t, "running", jsonResponse["status"])
+	assert.Equal(t, "building", jsonResponse["commands"].([]any)[0].(map[string]any)["output"], "Running deployment should contain the output of its commands")
+}
+
+func TestAPIDeploy(t *testing.T) {
+	t.Setenv("API_TOKEN", "helloworld")
+
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Commands = []Step{{Run: "touch deployed.txt"}}
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	Settings.Repositories = map[string]RepositoryConfig{"gitomatically": repository}
+	Settings.Preference.Workers = 1
+
+	StartQueue()
+	t.Cleanup(StopQueue)
+
+	res, _ := sendAPIRequest(t, "POST", "/api/repositories/unknown/deploy", "helloworld", "")
+
+	assert.Equal(t, http.StatusNotFound, res.Code, "Unknown repository should return 404")
+
+	res, _ = sendAPIRequest(t, "POST", "/api/repositories/gitomatically/deploy", "helloworld", `{"sha":"main"}`)
+
+	assert.Equal(t, http.StatusBadRequest, res.Code, "Invalid sha should return 400")
+
+	res, _ = sendAPIRequest(t, "POST", "/api/repositories/gitomatically/deploy", "helloworld", `{"branch":"develop"}`)
+
+	assert.Equal(t, http.StatusBadRequest, res.Code, "Branch that is not configured should return 400")
+
+	res, jsonResponse := sendAPIRequest(t, "POST", "/api/repositories/gitomatically/deploy", "helloworld", `{"skip_pull":true}`)
+
+	assert.Equal(t, http.StatusAccepted, res.Code, "Deploy should return 202")
+	assert.Equal(t, "Deployment is queued", jsonResponse["message"])
+
+	var job Job
+
+	assert.Eventually(t, func() bool {
+		job, _ = GetJob(jsonResponse["job"].(string))
+		return !job.FinishedAt.IsZero()
+	}, 10*time.Second, 10*time.Millisecond, "Deployment should finish")
+
+	assert.Equal(t, "manual", job.Trigger, "Deployment should be triggered manually")
+	assert.Equal(t, JobSucceeded, job.Status, "Deployment should run without new commits")
+	assert.FileExists(t, filepath.Join(repository.Path, "deployed.txt"), "Manual deployment should run the commands")
+}
+
+func TestAPIDeploymentStream(t *testing.T) {
+	t.Setenv("API_TOKEN", "helloworld")
+
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Name = "gitomatically"
+	repository.Commands = []Step{{Run: "echo hello"}, {Run: "ls missing-file"}}
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	apiTestHistory(t)
+
+	Settings.Preference.Workers = 1
+
+	StartQueue()
+	t.Cleanup(StopQueue)
+
+	job := NewJob(repository, PushEvent{Branch: "master"}, "manual", DeployOptions{SkipPull: true})
+
+	err = Enqueue(job)
+
+	if err != nil {
+		t.Fatalf("Enqueue error %v", err)
+	}
+
+	// The stream returns once the deployment finishes
+	res, _ := sendAPIRequest(t, "GET", fmt.Sprintf("/api/deployments/%v/stream?token=helloworld", job.Id), "", "")
+
+	assert.Equal(t, http.StatusOK, res.Code, "Stream should return 200")
+	assert.Equal(t, "text/event-stream", res.Header().Get("Content-Type"))
+	assert.Contains(t, res.Body.String(), `"stream":"stdout","text":"hello"`, "Stream should contain the stdout lines")
+	assert.Contains(t, res.Body.String(), `"stream":"stderr"`, "Stream should contain the stderr lines")
+	assert.Contains(t, res.Body.String(), "event:end\ndata:{\"status\":\"failed\"}", "Stream should end with the status")
+
+	res, _ = sendAPIRequest(t, "GET", "/api/deployments/job-1/stream", "helloworld", "")
+
+	assert.Contains(t, res.Body.String(), `"text":"started"`, "Stream of an older deployment should contain the recorded output")
+
+	res, _ = sendAPIRequest(t, "GET", "/api/deployments/job-1/stream?token=wrong", "", "")
+
+	assert.Equal(t, http.StatusUnauthorized, res.Code, "Stream should check the token")
+}
+
+func TestRedactToken(t *testing.T) {
+	assert.Equal(t, "/api/deployments/1/stream?token=redacted", RedactToken("/api/deployments/1/stream?token=helloworld"), "Token should be redacted")
+	assert.Equal(t, "/api/deployments?page=2&repo=gitomatically", RedactToken("/api/deployments?page=2&repo=gitomatically"), "Query without token should be kept")
+	assert.Equal(t, "/dashboard", RedactToken("/dashboard"), "Path without query should be kept")
+
+	line := LogFormatter(gin.LogFormatterParams{Path: "/api/deployments/1/stream?token=helloworld", Method: "GET", StatusCode: 200})
+
+	assert.NotContains(t, line, "helloworld", "Request log should not contain the token")
+}
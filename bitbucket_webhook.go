@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type BitbucketLink struct {
+	Href string `json:"href"`
+}
+
+type BitbucketLinks struct {
+	Html BitbucketLink `json:"html"`
+	// Bitbucket Cloud sends self as an object while Bitbucket Server sends an array
+	Self json.RawMessage `json:"self"`
+}
+
+type BitbucketRepository struct {
+	Links BitbucketLinks `json:"links"`
+}
+
+type BitbucketTarget struct {
+	Hash string `json:"hash"`
+}
+
+type BitbucketCloudRef struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name"`
+	Target BitbucketTarget `json:"target"`
+}
+
+type BitbucketCommitAuthor struct {
+	Raw string `json:"raw"`
+}
+
+type BitbucketCommit struct {
+	Hash    string                `json:"hash"`
+	Message string                `json:"message"`
+	Author  BitbucketCommitAuthor `json:"author"`
+}
+
+type BitbucketCloudChange struct {
+	Old     *BitbucketCloudRef `json:"old"`
+	New     *BitbucketCloudRef `json:"new"`
+	Commits []BitbucketCommit  `json:"commits"`
+}
+
+type BitbucketPush struct {
+	Changes []BitbucketCloudChange `json:"changes"`
+}
+
+type BitbucketServerRef struct {
+	Id        string `json:"id"`
+	DisplayId string `json:"displayId"`
+	Type      string `json:"type"`
+}
+
+type BitbucketServerChange struct {
+	Ref      BitbucketServerRef `json:"ref"`
+	FromHash string             `json:"fromHash"`
+	ToHash   string             `json:"toHash"`
+	Type     string             `json:"type"`
+}
+
+type BitbucketActor struct {
+	// Bitbucket Cloud
+	DisplayName string `json:"display_name"`
+	// Bitbucket Server
+	Name string `json:"name"`
+}
+
+type BitbucketResponse struct {
+	Actor      BitbucketActor          `json:"actor"`
+	Repository BitbucketRepository     `json:"repository"`
+	Push       BitbucketPush           `json:"push"`
+	Changes    []BitbucketServerChange `json:"changes"`
+}
+
+func (r BitbucketResponse) RepositoryUrl() string {
+	if r.Repository.Links.Html.Href != "" {
+		return r.Repository.Links.Html.Href
+	}
+
+	var links []BitbucketLink
+
+	if err := json.Unmarshal(r.Repository.Links.Self, &links); err != nil || len(links) == 0 {
+		return ""
+	}
+
+	return strings.TrimSuffix(links[0].Href, "/browse")
+}
+
+func (r BitbucketResponse) Pusher() string {
+	if r.Actor.DisplayName != "" {
+		return r.Actor.DisplayName
+	}
+
+	return r.Actor.Name
+}
+
+type BitbucketProvider struct{}
+
+func (BitbucketProvider) Name() string {
+	return "bitbucket"
+}
+
+func (BitbucketProvider) Verify(r *http.Request, body []byte) error {
+	signatureHeader := r.Header.Get("X-Hub-Signature")
+
+	if !strings.HasPrefix(signatureHeader, "sha256=") {
+		return MissingHeaderError{Header: "X-Hub-Signature"}
+	}
+
+	secret := os.Getenv("BITBUCKET_WEBHOOK_SECRET")
+
+	if secret == "" || !ValidSignature(secret, body, strings.TrimPrefix(signatureHeader, "sha256=")) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Parse returns one event per updated branch or tag, a single push can update several branches at once
+func (p BitbucketProvider) Parse(r *http.Request, body []byte) ([]PushEvent, error) {
+	event := r.Header.Get("X-Event-Key")
+
+	if event != "repo:push" && event != "repo:refs_changed" {
+		return nil, ErrEventIgnored
+	}
+
+	var response BitbucketResponse
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	var events []PushEvent
+
+	for _, change := range response.Push.Changes {
+		// new is null when the branch is deleted
+		if change.New == nil {
+			continue
+		}
+
+		pushEvent := PushEvent{
+			Provider:      p.Name(),
+			Event:         "push",
+			RepositoryUrl: response.RepositoryUrl(),
+			After:         change.New.Target.Hash,
+			Pusher:        response.Pusher(),
+		}
+
+		switch change.New.Type {
+		case "branch":
+			pushEvent.Ref = fmt.Sprintf("refs/heads/%v", change.New.Name)
+			pushEvent.Branch = change.New.Name
+		case "tag":
+			pushEvent.Ref = fmt.Sprintf("refs/tags/%v", change.New.Name)
+			pushEvent.Tag = change.New.Name
+		default:
+			continue
+		}
+
+		if change.Old != nil {
+			pushEvent.Before = change.Old.Target.Hash
+		}
+
+		for _, commit := range change.Commits {
+			pushEvent.Commits = append(pushEvent.Commits, Commit{Id: commit.Hash, Message: commit.Message, Author: commit.Author.Raw})
+		}
+
+		events = append(events, pushEvent)
+	}
+
+	for _, change := range response.Changes {
+		if change.Type == "DELETE" {
+			continue
+		}
+
+		pushEvent := PushEvent{
+			Provider:      p.Name(),
+			Event:         "push",
+			RepositoryUrl: response.RepositoryUrl(),
+			Ref:           change.Ref.Id,
+			Before:        change.FromHash,
+			After:         change.ToHash,
+			Pusher:        response.Pusher(),
+		}
+
+		switch change.Ref.Type {
+		case "BRANCH":
+			pushEvent.Branch = change.Ref.DisplayId
+		case "TAG":
+			pushEvent.Tag = change.Ref.DisplayId
+		default:
+			continue
+		}
+
+		events = append(events, pushEvent)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var bitbucketCloudPayload = `{
+	"actor": {"display_name": "Devin"},
+	"repository": {"links": {"html": {"href": "https://bitbucket.org/khouwdevin/gitomatically"}}},
+	"push": {"changes": [
+		{"old": {"type": "branch", "name": "master", "target": {"hash": "95790bf891e76fee5e1747ab589903a6a1f80f22"}}, "new": {"type": "branch", "name": "master", "target": {"hash": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"}}, "commits": [{"hash": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", "message": "fix: typo", "author": {"raw": "Devin <devin@example.com>"}}]},
+		{"old": null, "new": {"type": "branch", "name": "staging", "target": {"hash": "0b4f09c3e6c9ef40349f7d38b5d27d7da1560886"}}},
+		{"new": {"type": "tag", "name": "v1.0.0", "target": {"hash": "0b4f09c3e6c9ef40349f7d38b5d27d7da1560886"}}},
+		{"new": null}
+	]}
+}`
+
+var bitbucketServerPayload = `{
+	"actor": {"name": "devin"},
+	"repository": {"links": {"self": [{"href": "https://bitbucket.example.com/projects/KD/repos/gitomatically/browse"}]}},
+	"changes": [
+		{"ref": {"id": "refs/heads/master", "displayId": "master", "type": "BRANCH"}, "fromHash": "95790bf891e76fee5e1747ab589903a6a1f80f22", "toHash": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", "type": "UPDATE"},
+		{"ref": {"id": "refs/heads/old", "displayId": "old", "type": "BRANCH"}, "toHash": "0000000000000000000000000000000000000000", "type": "DELETE"}
+	]
+}`
+
+func TestBitbucketProviderVerify(t *testing.T) {
+	t.Setenv("BITBUCKET_WEBHOOK_SECRET", "helloworld")
+
+	provider := BitbucketProvider{}
+
+	mac := hmac.New(sha256.New, []byte("helloworld"))
+	mac.Write([]byte(bitbucketCloudPayload))
+
+	req := httptest.NewRequest("POST", "/webhook/bitbucket", nil)
+	req.Header.Set("X-Hub-Signature", fmt.Sprintf("sha256=%v", hex.EncodeToString(mac.Sum(nil))))
+
+	assert.NoError(t, provider.Verify(req, []byte(bitbucketCloudPayload)), "Verify should accept a valid signature")
+	assert.ErrorIs(t, provider.Verify(req, []byte(bitbucketServerPayload)), ErrInvalidSignature, "Verify should reject a signature of another body")
+}
+
+func TestBitbucketCloudPayload(t *testing.T) {
+	req := httptest.NewRequest("POST", "/webhook/bitbucket", nil)
+	req.Header.Set("X-Event-Key", "repo:push")
+
+	events, err := BitbucketProvider{}.Parse(req, []byte(bitbucketCloudPayload))
+
+	assert.NoError(t, err, "Parse Bitbucket Cloud payload should not return an error")
+	assert.Len(t, events, 3, "Parse should return one event per updated branch or tag")
+	assert.Equal(t, PushEvent{
+		Provider:      "bitbucket",
+		Event:         "push",
+		RepositoryUrl: "https://bitbucket.org/khouwdevin/gitomatically",
+		Ref:           "refs/heads/master",
+		Branch:        "master",
+		Before:        "95790bf891e76fee5e1747ab589903a6a1f80f22",
+		After:         "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		Pusher:        "Devin",
+		Commits:       []Commit{{Id: "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", Message: "fix: typo", Author: "Devin <devin@example.com>"}},
+	}, events[0], "First event should be the master branch")
+	assert.Equal(t, "staging", events[1].Branch, "Second event should be the staging branch")
+	assert.Equal(t, "v1.0.0", events[2].Tag, "Third event should be the v1.0.0 tag")
+	assert.Empty(t, events[2].Branch, "Tag event should not have a branch")
+}
+
+func TestBitbucketServerPayload(t *testing.T) {
+	req := httptest.NewRequest("POST", "/webhook/bitbucket", nil)
+	req.Header.Set("X-Event-Key", "repo:refs_changed")
+
+	events, err := BitbucketProvider{}.Parse(req, []byte(bitbucketServerPayload))
+
+	assert.NoError(t, err, "Parse Bitbucket Server payload should not return an error")
+	assert.Len(t, events, 1, "Parse should not return the deleted branch")
+	assert.Equal(t, "https://bitbucket.example.com/projects/KD/repos/gitomatically", events[0].RepositoryUrl, "Repository url should be the self link without browse")
+	assert.Equal(t, "master", events[0].Branch, "Branch should be the display id")
+	assert.Equal(t, "devin", events[0].Pusher, "Pusher should be the actor name")
+}
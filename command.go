@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrEmptyCommand = errors.New("command is empty")
+
+	// secretVariables are the variables of gitomatically that commands do not inherit when secrets are scrubbed
+	secretVariables = []string{"GITHUB_WEBHOOK_SECRET", "GITLAB_WEBHOOK_SECRET", "GITEA_WEBHOOK_SECRET", "BITBUCKET_WEBHOOK_SECRET", "API_TOKEN"}
+)
+
+// NewCommand prepares a step of the repository. The command is passed as a single argument to the shell of the step or
+// the repository, or split into words and executed directly without shell. It runs in the dir of the step relative to
+// the repository path, with the variables of the step added to the environment.
+func NewCommand(ctx context.Context, repository RepositoryConfig, step Step, env []string) (*exec.Cmd, error) {
+	env = append(inheritedEnv(), env...)
+
+	names := slices.Sorted(maps.Keys(step.Env))
+
+	for _, name := range names {
+		env = append(env, fmt.Sprintf("%v=%v", name, step.Env[name]))
+	}
+
+	shell := step.Shell
+
+	if shell == "" {
+		shell = repository.Shell
+	}
+
+	args, err := CommandArgs(step.Run, shell, env)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = filepath.Join(repository.Path, step.Dir)
+	cmd.Env = env
+
+	prepareCommand(cmd)
+
+	return cmd, nil
+}
+
+// inheritedEnv returns the environment of gitomatically without its secrets when they are scrubbed
+func inheritedEnv() []string {
+	env := os.Environ()
+
+	if !Settings.Preference.ScrubSecrets {
+		return env
+	}
+
+	return slices.DeleteFunc(env, func(variable string) bool {
+		name, _, _ := strings.Cut(variable, "=")
+
+		return slices.Contains(secretVariables, name)
+	})
+}
+
+// CommandArgs returns the program and the arguments to run the command with
+func CommandArgs(command string, shell string, env []string) ([]string, error) {
+	getenv := lookupEnv(env)
+
+	if shell == "" {
+		return SplitWords(command, getenv)
+	}
+
+	args, err := SplitWords(shell, getenv)
+
+	if err != nil {
+		return nil, fmt.Errorf("shell %v is invalid: %w", shell, err)
+	}
+
+	if strings.TrimSpace(command) == "" {
+		return nil, ErrEmptyCommand
+	}
+
+	return append(args, command), nil
+}
+
+// lookupEnv returns the value of a variable, the last assignment wins like it does for the started process
+func lookupEnv(env []string) func(string) string {
+	values := map[string]string{}
+
+	for _, variable := range env {
+		if name, value, ok := strings.Cut(variable, "="); ok {
+			values[name] = value
+		}
+	}
+
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+// SplitWords splits a command into words like a POSIX shell does. Single quotes keep their content as is, double
+// quotes and backslashes escape spaces, and $NAME or ${NAME} are replaced by the variable outside of single quotes.
+// Operators such as pipes and redirects are rejected because they need a shell.
+func SplitWords(command string, getenv func(string) string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+
+	// inWord is set once a word is started, so an empty quoted string is kept as an argument
+	inWord := false
+	runes := []rune(command)
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '\\':
+			if i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			}
+
+			inWord = true
+		case r == '\'':
+			end := indexRune(runes, i+1, '\'')
+
+			if end < 0 {
+				return nil, errors.New("single quote is not closed")
+			}
+
+			word.WriteString(string(runes[i+1 : end]))
+			i = end
+			inWord = true
+		case r == '"':
+			end, err := readDoubleQuoted(runes, i+1, &word, getenv)
+
+			if err != nil {
+				return nil, err
+			}
+
+			i = end
+			inWord = true
+		case r == '$':
+			i = expandVariable(runes, i, &word, getenv)
+			inWord = true
+		case strings.ContainsRune("|&;<>()`", r):
+			return nil, fmt.Errorf("%q needs a shell, set the shell of the command or the repository", r)
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	if len(words) == 0 {
+		return nil, ErrEmptyCommand
+	}
+
+	return words, nil
+}
+
+func indexRune(runes []rune, start int, r rune) int {
+	for i := start; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// readDoubleQuoted writes the content of a double quoted string to the word and returns the index of the closing quote
+func readDoubleQuoted(runes []rune, start int, word *strings.Builder, getenv func(string) string) (int, error) {
+	for i := start; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r == '"':
+			return i, nil
+		case r == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]):
+			i++
+			word.WriteRune(runes[i])
+		case r == '$':
+			i = expandVariable(runes, i, word, getenv)
+		default:
+			word.WriteRune(r)
+		}
+	}
+
+	return 0, errors.New("double quote is not closed")
+}
+
+// expandVariable writes the value of the variable starting at the dollar sign and returns the index of its last rune,
+// a dollar sign that does not start a variable name is kept
+func expandVariable(runes []rune, dollar int, word *strings.Builder, getenv func(string) string) int {
+	if dollar+1 < len(runes) && runes[dollar+1] == '{' {
+		end := indexRune(runes, dollar+2, '}')
+
+		if end < 0 {
+			word.WriteRune('$')
+			return dollar
+		}
+
+		word.WriteString(getenv(string(runes[dollar+2 : end])))
+
+		return end
+	}
+
+	end := dollar + 1
+
+	for end < len(runes) && isNameRune(runes[end], end == dollar+1) {
+		end++
+	}
+
+	if end == dollar+1 {
+		word.WriteRune('$')
+		return dollar
+	}
+
+	word.WriteString(getenv(string(runes[dollar+1 : end])))
+
+	return end - 1
+}
+
+func isNameRune(r rune, first bool) bool {
+	if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+		return true
+	}
+
+	return !first && r >= '0' && r <= '9'
+}
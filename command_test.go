@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitWords(t *testing.T) {
+	getenv := lookupEnv([]string{"NAME=world", "FILE=docker-compose.prod.yml", "NAME=gitomatically"})
+
+	tests := map[string][]string{
+		`docker compose -f "docker-compose.prod.yml" up -d`: {"docker", "compose", "-f", "docker-compose.prod.yml", "up", "-d"},
+		`echo  hello   world`:                               {"echo", "hello", "world"},
+		`echo 'a && b' "c d"`:                               {"echo", "a && b", "c d"},
+		`echo "" ''`:                                        {"echo", "", ""},
+		`echo hello\ world`:                                 {"echo", "hello world"},
+		`echo "say \"hi\""`:                                 {"echo", `say "hi"`},
+		`echo $NAME ${FILE} "$NAME" '$NAME'`:                {"echo", "gitomatically", "docker-compose.prod.yml", "gitomatically", "$NAME"},
+		`echo $MISSING-x price$ $1`:                         {"echo", "-x", "price$", "$1"},
+	}
+
+	for command, expected := range tests {
+		words, err := SplitWords(command, getenv)
+
+		assert.NoError(t, err, "SplitWords should not return an error for %v", command)
+		assert.Equal(t, expected, words, "SplitWords should split %v", command)
+	}
+}
+
+func TestSplitWordsError(t *testing.T) {
+	for _, command := range []string{`echo "hello`, `echo 'hello`, `echo a | grep a`, `echo a > file`, `a && b`, ``, `   `} {
+		_, err := SplitWords(command, os.Getenv)
+
+		assert.Error(t, err, "SplitWords should return an error for %q", command)
+	}
+}
+
+func TestCommandArgsShell(t *testing.T) {
+	args, err := CommandArgs("npm ci && npm run build | tee build.log", "bash -euo pipefail -c", nil)
+
+	assert.NoError(t, err, "CommandArgs should not return an error")
+	assert.Equal(t, []string{"bash", "-euo", "pipefail", "-c", "npm ci && npm run build | tee build.log"}, args, "Command should be a single argument of the shell")
+}
+
+func TestRunCommandsShell(t *testing.T) {
+	dirPath := t.TempDir()
+
+	repository := RepositoryConfig{
+		Path:     dirPath,
+		Shell:    "/bin/sh -c",
+		Commands: []Step{{Run: "echo hello | tr a-z A-Z > shell.txt && echo $GITOMATICALLY_TAG >> shell.txt"}},
+	}
+
+	err := RunCommands(context.Background(), NewJob(repository, PushEvent{}, "manual", DeployOptions{}), repository, []string{"GITOMATICALLY_TAG=v1.0.0"})
+
+	assert.NoError(t, err, "RunCommands should not return an error")
+
+	data, err := os.ReadFile(filepath.Join(dirPath, "shell.txt"))
+
+	assert.NoError(t, err, "Shell command should write the file")
+	assert.Equal(t, "HELLO\nv1.0.0\n", string(data), "Shell should run pipes, redirects and expand variables")
+}
+
+func TestRunCommandsQuoted(t *testing.T) {
+	dirPath := t.TempDir()
+
+	repository := RepositoryConfig{
+		Path:     dirPath,
+		Commands: []Step{{Run: `touch "with space.txt" $GITOMATICALLY_TAG.txt`}},
+	}
+
+	err := RunCommands(context.Background(), NewJob(repository, PushEvent{}, "manual", DeployOptions{}), repository, []string{"GITOMATICALLY_TAG=v1.0.0"})
+
+	assert.NoError(t, err, "RunCommands should not return an error")
+	assert.FileExists(t, filepath.Join(dirPath, "with space.txt"), "Quoted argument should not be split")
+	assert.FileExists(t, filepath.Join(dirPath, "v1.0.0.txt"), "Variable should be expanded")
+}
+
+func TestNewCommandScrubSecrets(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	t.Setenv("GITHUB_WEBHOOK_SECRET", "helloworld")
+	t.Setenv("API_TOKEN", "helloworld")
+	t.Setenv("GITOMATICALLY_TEST", "kept")
+
+	cmd, err := NewCommand(context.Background(), RepositoryConfig{}, Step{Run: "true"}, nil)
+
+	assert.NoError(t, err, "NewCommand should not return an error")
+	assert.Contains(t, cmd.Env, "GITHUB_WEBHOOK_SECRET=helloworld", "Secrets should be inherited by default")
+
+	Settings.Preference.ScrubSecrets = true
+
+	cmd, err = NewCommand(context.Background(), RepositoryConfig{}, Step{Run: "true"}, nil)
+
+	assert.NoError(t, err, "NewCommand should not return an error")
+	assert.NotContains(t, cmd.Env, "GITHUB_WEBHOOK_SECRET=helloworld", "Webhook secret should be scrubbed")
+	assert.NotContains(t, cmd.Env, "API_TOKEN=helloworld", "API token should be scrubbed")
+	assert.Contains(t, cmd.Env, "GITOMATICALLY_TEST=kept", "Other variables should be inherited")
+}
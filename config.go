@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -19,14 +23,241 @@ type PreferenceSettings struct {
 	Paraphrase string `yaml:"paraphrase"`
 	Cron       bool   `yaml:"cron"`
 	Spec       string `yaml:"spec"`
+
+	DataDir             string `yaml:"data_dir"`
+	DeliveryHistory     int    `yaml:"delivery_history"`
+	SkipDeployedCommits bool   `yaml:"skip_deployed_commits"`
+	DeploymentHistory   int    `yaml:"deployment_history"`
+	Workers             int    `yaml:"workers"`
+	QueueLimit          int    `yaml:"queue_limit"`
+	ScrubSecrets        bool   `yaml:"scrub_secrets"`
+}
+
+type HookConfig struct {
+	Token  string `yaml:"token"`
+	Secret string `yaml:"secret"`
+	Header string `yaml:"header"`
+}
+
+func (h HookConfig) Enabled() bool {
+	return h.Token != "" || h.Secret != ""
+}
+
+func (h HookConfig) SignatureHeader() string {
+	if h.Header == "" {
+		return "X-Signature-256"
+	}
+
+	return h.Header
+}
+
+// Step is a command of a deployment, it is written as a plain command or as a mapping with its options
+type Step struct {
+	Name  string            `yaml:"name"`
+	Run   string            `yaml:"run"`
+	Dir   string            `yaml:"dir"`
+	Env   map[string]string `yaml:"env"`
+	Shell string            `yaml:"shell"`
+	// Timeout stops the command when it runs longer, zero means no timeout
+	Timeout         time.Duration `yaml:"timeout"`
+	Retries         int           `yaml:"retries"`
+	RetryDelay      time.Duration `yaml:"retry_delay"`
+	ContinueOnError bool          `yaml:"continue_on_error"`
+}
+
+// UnmarshalYAML accepts a plain command as well as the mapping form
+func (s *Step) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		s.Run = value.Value
+		return nil
+	}
+
+	type rawStep Step
+
+	return value.Decode((*rawStep)(s))
+}
+
+// String returns the name of the step, or its command when it has no name
+func (s Step) String() string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	return s.Run
+}
+
+// HealthcheckConfig checks the app after a deployment with one of an HTTP request, a TCP connection or a command
+type HealthcheckConfig struct {
+	Url string `yaml:"url"`
+	// Status is the expected status code of the HTTP response
+	Status int `yaml:"status"`
+	// Body is a regular expression the HTTP response body must match
+	Body    string `yaml:"body"`
+	Tcp     string `yaml:"tcp"`
+	Command string `yaml:"command"`
+
+	Retries  int           `yaml:"retries"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+func (h HealthcheckConfig) Enabled() bool {
+	return h.Url != "" || h.Tcp != "" || h.Command != ""
+}
+
+type BranchConfig struct {
+	Name     string `yaml:"name"`
+	Path     string `yaml:"path"`
+	Commands []Step `yaml:"commands"`
+}
+
+// UnmarshalYAML accepts a plain branch name as well as the mapping form
+func (b *BranchConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		b.Name = value.Value
+		return nil
+	}
+
+	type rawBranchConfig BranchConfig
+
+	return value.Decode((*rawBranchConfig)(b))
 }
 
 type RepositoryConfig struct {
-	Url      string   `yaml:"url"`
-	Clone    string   `yaml:"clone"`
-	Branch   string   `yaml:"branch"`
-	Path     string   `yaml:"path"`
-	Commands []string `yaml:"commands"`
+	Name string `yaml:"-"`
+
+	Provider   string         `yaml:"provider"`
+	Url        string         `yaml:"url"`
+	Clone      string         `yaml:"clone"`
+	Branch     string         `yaml:"branch"`
+	Branches   []BranchConfig `yaml:"branches"`
+	DeployOn   string         `yaml:"deploy_on"`
+	TagPattern string         `yaml:"tag_pattern"`
+	Path       string         `yaml:"path"`
+	Commands   []Step         `yaml:"commands"`
+	Shell      string         `yaml:"shell"`
+	Hook       HookConfig     `yaml:"hook"`
+
+	CancelInProgress bool   `yaml:"cancel_in_progress"`
+	OnInterrupt      string `yaml:"on_interrupt"`
+
+	Healthcheck HealthcheckConfig `yaml:"healthcheck"`
+
+	PrePull   []Step `yaml:"pre_pull"`
+	PostPull  []Step `yaml:"post_pull"`
+	OnSuccess []Step `yaml:"on_success"`
+	OnFailure []Step `yaml:"on_failure"`
+	Always    []Step `yaml:"always"`
+
+	PreviewPath      string `yaml:"preview_path"`
+	PreviewCommands  []Step `yaml:"preview_commands"`
+	TeardownCommands []Step `yaml:"teardown_commands"`
+	// AllowForks deploys previews of pull requests from forks, which runs code of anyone who can open a pull request
+	AllowForks bool `yaml:"allow_forks"`
+}
+
+func (r RepositoryConfig) DeploysTags() bool {
+	return r.DeployOn == "tags"
+}
+
+func (r RepositoryConfig) MatchesRef(event PushEvent) bool {
+	if r.DeploysTags() {
+		if event.Tag == "" {
+			return false
+		}
+
+		matched, _ := path.Match(r.TagPattern, event.Tag)
+
+		return matched
+	}
+
+	_, ok := r.ForBranch(event.Branch)
+
+	return ok
+}
+
+func (r RepositoryConfig) PreviewsEnabled() bool {
+	return len(r.PreviewCommands) > 0
+}
+
+// PreviewDirectory returns the directory of a pull request preview, the default is next to the repository path
+func (r RepositoryConfig) PreviewDirectory(number int) string {
+	previewPath := r.PreviewPath
+
+	if previewPath == "" {
+		previewPath = fmt.Sprintf("%v-previews", r.Path)
+	}
+
+	return filepath.Join(previewPath, fmt.Sprintf("pr-%v", number))
+}
+
+// ForEvent returns the config to deploy a matching event with
+func (r RepositoryConfig) ForEvent(event PushEvent) RepositoryConfig {
+	if repository, ok := r.ForBranch(event.Branch); ok && !r.DeploysTags() {
+		return repository
+	}
+
+	return r
+}
+
+// BranchConfigs returns the branches list with the single branch in front of it
+func (r RepositoryConfig) BranchConfigs() []BranchConfig {
+	if r.Branch == "" || slices.ContainsFunc(r.Branches, func(b BranchConfig) bool { return b.Name == r.Branch }) {
+		return r.Branches
+	}
+
+	return append([]BranchConfig{{Name: r.Branch}}, r.Branches...)
+}
+
+// ForBranch returns the config of the first branch pattern matching the branch with its overrides applied
+func (r RepositoryConfig) ForBranch(branch string) (RepositoryConfig, bool) {
+	if branch == "" {
+		return RepositoryConfig{}, false
+	}
+
+	for _, branchConfig := range r.BranchConfigs() {
+		matched, _ := path.Match(branchConfig.Name, branch)
+
+		if !matched {
+			continue
+		}
+
+		repository := r
+		repository.Branch = branch
+
+		if branchConfig.Path != "" {
+			repository.Path = branchConfig.Path
+		}
+
+		if branchConfig.Commands != nil {
+			repository.Commands = branchConfig.Commands
+		}
+
+		return repository, true
+	}
+
+	return RepositoryConfig{}, false
+}
+
+// Expand returns the config of every branch without pattern, these are the branches that can be deployed without a webhook
+func (r RepositoryConfig) Expand() []RepositoryConfig {
+	if r.DeploysTags() {
+		return []RepositoryConfig{r}
+	}
+
+	var repositories []RepositoryConfig
+
+	for _, branchConfig := range r.BranchConfigs() {
+		if strings.ContainsAny(branchConfig.Name, "*?[\\") {
+			continue
+		}
+
+		if repository, ok := r.ForBranch(branchConfig.Name); ok {
+			repositories = append(repositories, repository)
+		}
+	}
+
+	return repositories
 }
 
 type Config struct {
@@ -61,73 +292,265 @@ func InitializeConfig(filePath string) error {
 		return errors.New("duration value is required.")
 	}
 
-	return nil
-}
+	if Settings.Preference.DataDir == "" {
+		Settings.Preference.DataDir = "data"
+	}
+	if Settings.Preference.DeliveryHistory <= 0 {
+		Settings.Preference.DeliveryHistory = 1000
+	}
+	if Settings.Preference.DeploymentHistory <= 0 {
+		Settings.Preference.DeploymentHistory = 500
+	}
+	if Settings.Preference.Workers <= 0 {
+		Settings.Preference.Workers = 2
+	}
+	if Settings.Preference.QueueLimit <= 0 {
+		Settings.Preference.QueueLimit = 20
+	}
+
+	for name, repository := range Settings.Repositories {
+		if repository.Provider == "" {
+			repository.Provider = "github"
+		}
+
+		if repository.DeployOn == "" {
+			repository.DeployOn = "branches"
+		}
+
+		if repository.DeployOn != "branches" && repository.DeployOn != "tags" {
+			return fmt.Errorf("deploy_on of %v repository must be branches or tags.", name)
+		}
+
+		if repository.OnInterrupt == "" {
+			repository.OnInterrupt = "alert"
+		}
+
+		if !slices.Contains([]string{"rerun", "rollback", "alert"}, repository.OnInterrupt) {
+			return fmt.Errorf("on_interrupt of %v repository must be rerun, rollback or alert.", name)
+		}
 
-func PreStart() error {
-	for _, repository := range Settings.Repositories {
-		_, err := os.Stat(filepath.Join(repository.Path, ".git"))
+		if repository.Shell != "" {
+			if _, err := SplitWords(repository.Shell, os.Getenv); err != nil {
+				return fmt.Errorf("shell of %v repository is invalid.", name)
+			}
+		}
 
-		if err == nil {
-			slog.Debug(fmt.Sprintf("CONFIG Pulling %v", repository.Url))
+		for _, branchConfig := range repository.BranchConfigs() {
+			if _, err := path.Match(branchConfig.Name, ""); err != nil || branchConfig.Name == "" {
+				return fmt.Errorf("branch %v of %v repository is invalid.", branchConfig.Name, name)
+			}
 
-			err := GitPull(repository)
+			err := validateSteps(name, repository.Shell, branchConfig.Commands)
 
 			if err != nil {
-				if err == git.NoErrAlreadyUpToDate {
-					slog.Debug(fmt.Sprintf("CONFIG %v is up to date, continue to next repository", repository.Url))
-					continue
-				} else {
-					slog.Debug(fmt.Sprintf("CONFIG Git pull err output %v", err))
-					return err
-				}
+				return err
 			}
+		}
 
-			for _, command := range repository.Commands {
-				slog.Debug(fmt.Sprintf("CONFIG Running %v", command))
+		err := validateSteps(name, repository.Shell, slices.Concat(repository.Commands, repository.PreviewCommands, repository.TeardownCommands,
+			repository.PrePull, repository.PostPull, repository.OnSuccess, repository.OnFailure, repository.Always))
 
-				arrCommand := strings.Split(command, " ")
+		if err != nil {
+			return err
+		}
 
-				cmd := exec.Command(arrCommand[0], arrCommand[1:]...)
-				cmd.Dir = repository.Path
-				cmd.Env = os.Environ()
+		if repository.Healthcheck.Enabled() {
+			err := initializeHealthcheck(&repository.Healthcheck)
 
-				_, err := cmd.Output()
+			if err != nil {
+				return fmt.Errorf("healthcheck of %v repository %v", name, err)
+			}
+		}
 
-				if err != nil {
-					slog.Error("CONFIG Failed to run command")
-					break
-				}
+		if repository.DeploysTags() {
+			if repository.TagPattern == "" {
+				repository.TagPattern = "*"
 			}
-		} else if os.IsNotExist(err) {
-			slog.Info(fmt.Sprintf("CONFIG Cloning %v", repository.Url))
 
-			err := GitClone(repository)
+			if _, err := path.Match(repository.TagPattern, ""); err != nil {
+				return fmt.Errorf("tag_pattern of %v repository is invalid.", name)
+			}
+		}
+
+		if repository.Provider == "generic" {
+			if !repository.Hook.Enabled() {
+				return fmt.Errorf("hook token or secret of %v repository is required.", name)
+			}
+		} else if _, ok := GetProvider(repository.Provider); !ok {
+			return fmt.Errorf("%v provider of %v repository is not supported.", repository.Provider, name)
+		}
+
+		Settings.Repositories[name] = repository
+	}
+
+	return nil
+}
+
+func initializeHealthcheck(healthcheck *HealthcheckConfig) error {
+	checks := 0
+
+	for _, check := range []string{healthcheck.Url, healthcheck.Tcp, healthcheck.Command} {
+		if check != "" {
+			checks++
+		}
+	}
+
+	if checks != 1 {
+		return errors.New("must have only one of url, tcp or command.")
+	}
+
+	if _, err := regexp.Compile(healthcheck.Body); err != nil {
+		return errors.New("body is not a valid regular expression.")
+	}
+
+	if healthcheck.Status == 0 {
+		healthcheck.Status = 200
+	}
+	if healthcheck.Retries <= 0 {
+		healthcheck.Retries = 3
+	}
+	if healthcheck.Interval <= 0 {
+		healthcheck.Interval = 5 * time.Second
+	}
+	if healthcheck.Timeout <= 0 {
+		healthcheck.Timeout = 10 * time.Second
+	}
+
+	return nil
+}
+
+// validateSteps checks the steps of a repository, commands that run without shell are split into words so a missing
+// quote or a shell operator is reported on load instead of during a deployment
+func validateSteps(name string, shell string, steps []Step) error {
+	for _, step := range steps {
+		if strings.TrimSpace(step.Run) == "" {
+			return fmt.Errorf("command %v of %v repository has nothing to run.", step, name)
+		}
+
+		if step.Timeout < 0 || step.Retries < 0 || step.RetryDelay < 0 {
+			return fmt.Errorf("timeout, retries and retry_delay of command %v of %v repository must not be negative.", step, name)
+		}
+
+		if step.Shell != "" {
+			if _, err := SplitWords(step.Shell, os.Getenv); err != nil {
+				return fmt.Errorf("shell of command %v of %v repository is invalid.", step, name)
+			}
+		}
+
+		if step.Shell == "" && shell == "" {
+			if _, err := SplitWords(step.Run, os.Getenv); err != nil {
+				return fmt.Errorf("command %v of %v repository is invalid, %v.", step, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Repositories returns every repository branch that can be deployed without a webhook
+func Repositories() []RepositoryConfig {
+	var repositories []RepositoryConfig
+
+	for name, repository := range Settings.Repositories {
+		repository.Name = name
+		repositories = append(repositories, repository.Expand()...)
+	}
+
+	return repositories
+}
+
+// PreStart clones and pulls every repository, except the held worktrees
+func PreStart(held ...string) error {
+	for _, repository := range Repositories() {
+		if slices.Contains(held, repository.Path) {
+			slog.Info(fmt.Sprintf("CONFIG %v is held after an interrupted deployment, skip pull", repository.Path))
+			continue
+		}
+
+		err := prestartRepository(repository)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func prestartRepository(repository RepositoryConfig) error {
+	_, err := os.Stat(filepath.Join(repository.Path, ".git"))
+
+	if err == nil {
+		if repository.DeploysTags() {
+			slog.Debug(fmt.Sprintf("CONFIG %v deploys tags, skip pull", repository.Url))
+			return nil
+		}
+
+		slog.Debug(fmt.Sprintf("CONFIG Pulling %v %v", repository.Url, repository.Branch))
+
+		previousSha, _ := HeadSha(repository.Path)
+
+		// The pull hooks would stop the app for nothing when there is nothing to pull
+		if len(repository.PrePull) > 0 {
+			hasUpdate, err := GitHasUpdate(repository)
 
 			if err != nil {
+				slog.Debug(fmt.Sprintf("CONFIG Git fetch err output %v", err))
 				return err
 			}
 
-			for _, command := range repository.Commands {
-				slog.Debug(fmt.Sprintf("CONFIG Running %v", command))
+			if !hasUpdate {
+				slog.Debug(fmt.Sprintf("CONFIG %v is up to date, continue to next repository", repository.Url))
+				return nil
+			}
+		}
+
+		err := prestartDeploy(repository, previousSha, func() error { return GitPull(repository) })
+
+		if err == git.NoErrAlreadyUpToDate {
+			slog.Debug(fmt.Sprintf("CONFIG %v is up to date, continue to next repository", repository.Url))
+			return nil
+		}
 
-				arrCommand := strings.Split(command, " ")
+		var stepErr *StepError
 
-				cmd := exec.Command(arrCommand[0], arrCommand[1:]...)
-				cmd.Dir = repository.Path
-				cmd.Env = os.Environ()
+		// Only a failed pull stops the startup, failed commands are logged
+		if errors.As(err, &stepErr) && stepErr.Step.Name == "pull" {
+			slog.Debug(fmt.Sprintf("CONFIG Git pull err output %v", err))
+			return stepErr.Err
+		} else if err != nil {
+			slog.Error(fmt.Sprintf("CONFIG %v", err))
+		}
+	} else if os.IsNotExist(err) {
+		slog.Info(fmt.Sprintf("CONFIG Cloning %v", repository.Url))
 
-				output, err := cmd.Output()
+		if repository.DeploysTags() {
+			err := GitClone(repository)
 
-				if err != nil {
-					slog.Debug(fmt.Sprintf("CONFIG Command err output %v", string(output)))
-					return errors.New("failed to run command")
-				}
+			if err != nil {
+				return err
 			}
-		} else {
-			return err
+
+			slog.Info(fmt.Sprintf("CONFIG %v deploys tags, waiting for a tag to run commands", repository.Url))
+			return nil
 		}
+
+		// There is no worktree for the pre_pull hooks before the clone
+		clone := repository
+		clone.PrePull = nil
+
+		return prestartDeploy(clone, "", func() error { return GitClone(repository) })
+	} else {
+		return err
 	}
 
 	return nil
 }
+
+// prestartDeploy runs the deployment outside of the queue, the job only collects the output
+func prestartDeploy(repository RepositoryConfig, previousSha string, pull func() error) error {
+	job := NewJob(repository, PushEvent{Branch: repository.Branch}, "startup", DeployOptions{})
+	job.PreviousSha = previousSha
+
+	return DeployWithHooks(context.Background(), job, repository, nil, pull)
+}
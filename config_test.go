@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v3"
@@ -59,7 +60,7 @@ func TestInitializeConfigSuccess(t *testing.T) {
 				Clone:    "git@github.com:khouwdevin/gitomatically.git",
 				Branch:   "master",
 				Path:     filepath.Join(t.TempDir(), "gitomatically"),
-				Commands: []string{},
+				Commands: []Step{},
 			},
 		},
 	}
@@ -97,7 +98,7 @@ func TestInitializeDefaultConfig(t *testing.T) {
 				Clone:    "git@github.com:khouwdevin/gitomatically.git",
 				Branch:   "master",
 				Path:     filepath.Join(t.TempDir(), "gitomatically"),
-				Commands: []string{},
+				Commands: []Step{},
 			},
 		},
 	}
@@ -159,7 +160,7 @@ func TestInitializetConfigPrivateKeyMissing(t *testing.T) {
 				Clone:    "git@github.com:khouwdevin/gitomatically.git",
 				Branch:   "master",
 				Path:     filepath.Join(t.TempDir(), "gitomatically"),
-				Commands: []string{},
+				Commands: []Step{},
 			},
 		},
 	}
@@ -200,7 +201,7 @@ func TestInitializetConfigDurationMissing(t *testing.T) {
 				Clone:    "git@github.com:khouwdevin/gitomatically.git",
 				Branch:   "master",
 				Path:     filepath.Join(t.TempDir(), "gitomatically"),
-				Commands: []string{},
+				Commands: []Step{},
 			},
 		},
 	}
@@ -234,7 +235,7 @@ func TestPreStart(t *testing.T) {
 				Clone:    "git@github.com:khouwdevin/gitomatically.git",
 				Branch:   "master",
 				Path:     filepath.Join(t.TempDir(), "gitomatically"),
-				Commands: []string{},
+				Commands: []Step{},
 			},
 		},
 	}
@@ -243,3 +244,462 @@ func TestPreStart(t *testing.T) {
 
 	assert.NoError(t, err, "Prestart should not return an error")
 }
+
+func TestInitializeConfigProvider(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	sshPath, err := createTempSSH(t.TempDir())
+
+	if err != nil {
+		t.Error("Error creating temp ssh")
+	}
+
+	fileContent := Config{
+		Preference: PreferenceSettings{
+			PrivateKey: sshPath,
+		},
+		Repositories: map[string]RepositoryConfig{
+			"gitomatically": {
+				Url:      "https://github.com/khouwdevin/gitomatically",
+				Clone:    "git@github.com:khouwdevin/gitomatically.git",
+				Branch:   "master",
+				Path:     filepath.Join(t.TempDir(), "gitomatically"),
+				Commands: []Step{},
+			},
+			"gitomatically-gitlab": {
+				Provider: "gitlab",
+				Url:      "https://gitlab.com/khouwdevin/gitomatically",
+				Clone:    "git@gitlab.com:khouwdevin/gitomatically.git",
+				Branch:   "master",
+				Path:     filepath.Join(t.TempDir(), "gitomatically-gitlab"),
+				Commands: []Step{},
+			},
+		},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+
+	err = createTempYAMLFile(filePath, fileContent)
+
+	if err != nil {
+		t.Error("Cannot write temporary config file")
+	}
+
+	err = InitializeConfig(filePath)
+
+	assert.NoError(t, err, "InitializeConfig should not return an error")
+	assert.Equal(t, "github", Settings.Repositories["gitomatically"].Provider, "Provider should default to github")
+	assert.Equal(t, "gitlab", Settings.Repositories["gitomatically-gitlab"].Provider, "Provider should be kept")
+}
+
+func TestInitializeConfigProviderNotSupported(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	sshPath, err := createTempSSH(t.TempDir())
+
+	if err != nil {
+		t.Error("Error creating temp ssh")
+	}
+
+	fileContent := Config{
+		Preference: PreferenceSettings{
+			PrivateKey: sshPath,
+		},
+		Repositories: map[string]RepositoryConfig{
+			"gitomatically": {
+				Provider: "svn",
+				Url:      "https://github.com/khouwdevin/gitomatically",
+				Clone:    "git@github.com:khouwdevin/gitomatically.git",
+				Branch:   "master",
+				Path:     filepath.Join(t.TempDir(), "gitomatically"),
+				Commands: []Step{},
+			},
+		},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+
+	err = createTempYAMLFile(filePath, fileContent)
+
+	if err != nil {
+		t.Error("Cannot write temporary config file")
+	}
+
+	err = InitializeConfig(filePath)
+
+	assert.Error(t, err, "InitializeConfig should return an error")
+	assert.Contains(t, err.Error(), "svn provider of gitomatically repository is not supported.", "Error message should indicate the provider is not supported.")
+}
+
+func TestInitializeConfigGenericHookMissing(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	sshPath, err := createTempSSH(t.TempDir())
+
+	if err != nil {
+		t.Error("Error creating temp ssh")
+	}
+
+	fileContent := Config{
+		Preference: PreferenceSettings{
+			PrivateKey: sshPath,
+		},
+		Repositories: map[string]RepositoryConfig{
+			"gitomatically": {
+				Provider: "generic",
+				Url:      "https://git.example.com/khouwdevin/gitomatically",
+				Clone:    "git@git.example.com:khouwdevin/gitomatically.git",
+				Branch:   "master",
+				Path:     filepath.Join(t.TempDir(), "gitomatically"),
+				Commands: []Step{},
+			},
+		},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+
+	err = createTempYAMLFile(filePath, fileContent)
+
+	if err != nil {
+		t.Error("Cannot write temporary config file")
+	}
+
+	err = InitializeConfig(filePath)
+
+	assert.Error(t, err, "InitializeConfig should return an error")
+	assert.Contains(t, err.Error(), "hook token or secret of gitomatically repository is required.", "Error message should indicate the hook is not configured.")
+}
+
+func TestRepositoryMatchesRef(t *testing.T) {
+	branchRepository := RepositoryConfig{Branch: "master", DeployOn: "branches"}
+	tagRepository := RepositoryConfig{DeployOn: "tags", TagPattern: "v*.*.*"}
+
+	assert.True(t, branchRepository.MatchesRef(PushEvent{Branch: "master"}), "Branch repository should match its branch")
+	assert.False(t, branchRepository.MatchesRef(PushEvent{Branch: "staging"}), "Branch repository should not match another branch")
+	assert.False(t, branchRepository.MatchesRef(PushEvent{Tag: "v1.0.0"}), "Branch repository should not match a tag")
+	assert.True(t, tagRepository.MatchesRef(PushEvent{Tag: "v1.0.0"}), "Tag repository should match the tag pattern")
+	assert.False(t, tagRepository.MatchesRef(PushEvent{Tag: "nightly"}), "Tag repository should not match other tags")
+	assert.False(t, tagRepository.MatchesRef(PushEvent{Branch: "master"}), "Tag repository should not match a branch")
+}
+
+func TestInitializeConfigBranches(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	sshPath, err := createTempSSH(t.TempDir())
+
+	if err != nil {
+		t.Error("Error creating temp ssh")
+	}
+
+	fileContent := `
+preference:
+  private_key: ` + sshPath + `
+repositories:
+  gitomatically:
+    url: https://github.com/khouwdevin/gitomatically
+    clone: git@github.com:khouwdevin/gitomatically.git
+    path: /srv/staging
+    commands:
+      - docker compose up -d
+    branches:
+      - main
+      - name: release/*
+        path: /srv/production
+        commands:
+          - docker compose -f compose.production.yml up -d
+`
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+
+	err = os.WriteFile(filePath, []byte(fileContent), 0644)
+
+	if err != nil {
+		t.Error("Cannot write temporary config file")
+	}
+
+	err = InitializeConfig(filePath)
+
+	assert.NoError(t, err, "InitializeConfig should not return an error")
+
+	repository := Settings.Repositories["gitomatically"]
+
+	main, ok := repository.ForBranch("main")
+
+	assert.True(t, ok, "main branch should match")
+	assert.Equal(t, "/srv/staging", main.Path, "main branch should use the repository path")
+	assert.Equal(t, []Step{{Run: "docker compose up -d"}}, main.Commands, "main branch should use the repository commands")
+
+	release, ok := repository.ForBranch("release/1.2")
+
+	assert.True(t, ok, "release/1.2 branch should match release/*")
+	assert.Equal(t, "release/1.2", release.Branch, "Branch should be the pushed branch")
+	assert.Equal(t, "/srv/production", release.Path, "release branch should override the path")
+	assert.Equal(t, []Step{{Run: "docker compose -f compose.production.yml up -d"}}, release.Commands, "release branch should override the commands")
+
+	_, ok = repository.ForBranch("feature/login")
+
+	assert.False(t, ok, "feature/login branch should not match")
+
+	expanded := repository.Expand()
+
+	assert.Len(t, expanded, 1, "Only branches without pattern should be expanded")
+	assert.Equal(t, "main", expanded[0].Branch, "main branch should be expanded")
+}
+
+func TestInitializeConfigOnInterrupt(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	sshPath, err := createTempSSH(t.TempDir())
+
+	if err != nil {
+		t.Error("Error creating temp ssh")
+	}
+
+	for policy, expected := range map[string]string{"": "alert", "rollback": "rollback", "restart": ""} {
+		Settings = Config{}
+
+		fileContent := Config{
+			Preference: PreferenceSettings{
+				PrivateKey: sshPath,
+			},
+			Repositories: map[string]RepositoryConfig{
+				"gitomatically": {
+					Url:         "https://github.com/khouwdevin/gitomatically",
+					Clone:       "git@github.com:khouwdevin/gitomatically.git",
+					Branch:      "master",
+					Path:        filepath.Join(t.TempDir(), "gitomatically"),
+					OnInterrupt: policy,
+				},
+			},
+		}
+
+		filePath := filepath.Join(t.TempDir(), "config.yaml")
+
+		err = createTempYAMLFile(filePath, fileContent)
+
+		if err != nil {
+			t.Error("Cannot write temporary config file")
+		}
+
+		err = InitializeConfig(filePath)
+
+		if expected == "" {
+			assert.EqualError(t, err, "on_interrupt of gitomatically repository must be rerun, rollback or alert.", "Unknown policy should return an error")
+			continue
+		}
+
+		assert.NoError(t, err, "InitializeConfig should not return an error")
+		assert.Equal(t, expected, Settings.Repositories["gitomatically"].OnInterrupt, "Policy should be set")
+	}
+}
+
+func TestInitializeConfigShell(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	sshPath, err := createTempSSH(t.TempDir())
+
+	if err != nil {
+		t.Error("Error creating temp ssh")
+	}
+
+	fileContent := Config{
+		Preference: PreferenceSettings{
+			PrivateKey: sshPath,
+		},
+		Repositories: map[string]RepositoryConfig{
+			"gitomatically": {
+				Url:    "https://github.com/khouwdevin/gitomatically",
+				Clone:  "git@github.com:khouwdevin/gitomatically.git",
+				Branch: "master",
+				Path:   filepath.Join(t.TempDir(), "gitomatically"),
+				Shell:  "bash -c \"",
+			},
+		},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+
+	err = createTempYAMLFile(filePath, fileContent)
+
+	if err != nil {
+		t.Error("Cannot write temporary config file")
+	}
+
+	err = InitializeConfig(filePath)
+
+	assert.EqualError(t, err, "shell of gitomatically repository is invalid.", "Unclosed quote in shell should return an error")
+
+	repository := fileContent.Repositories["gitomatically"]
+	repository.Shell = ""
+	repository.Commands = []Step{{Run: "npm ci && npm run build"}}
+	fileContent.Repositories["gitomatically"] = repository
+
+	err = createTempYAMLFile(filePath, fileContent)
+
+	if err != nil {
+		t.Error("Cannot write temporary config file")
+	}
+
+	err = InitializeConfig(filePath)
+
+	assert.EqualError(t, err, "command npm ci && npm run build of gitomatically repository is invalid, '&' needs a shell, set the shell of the command or the repository.", "Shell operator without shell should return an error")
+
+	repository.Shell = "/bin/sh -c"
+	fileContent.Repositories["gitomatically"] = repository
+
+	err = createTempYAMLFile(filePath, fileContent)
+
+	if err != nil {
+		t.Error("Cannot write temporary config file")
+	}
+
+	assert.NoError(t, InitializeConfig(filePath), "Shell operator with the shell of the repository should be accepted")
+}
+
+func TestInitializeConfigSteps(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	sshPath, err := createTempSSH(t.TempDir())
+
+	if err != nil {
+		t.Error("Error creating temp ssh")
+	}
+
+	fileContent := `
+preference:
+  private_key: ` + sshPath + `
+repositories:
+  gitomatically:
+    url: https://github.com/khouwdevin/gitomatically
+    clone: git@github.com:khouwdevin/gitomatically.git
+    branch: main
+    path: /srv/gitomatically
+    commands:
+      - npm ci
+      - name: build
+        run: npm run build
+        dir: web
+        env:
+          NODE_ENV: production
+        timeout: 10m
+        retries: 2
+        retry_delay: 30s
+        continue_on_error: true
+`
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+
+	err = os.WriteFile(filePath, []byte(fileContent), 0644)
+
+	if err != nil {
+		t.Error("Cannot write temporary config file")
+	}
+
+	err = InitializeConfig(filePath)
+
+	assert.NoError(t, err, "InitializeConfig should not return an error")
+
+	expected := []Step{
+		{Run: "npm ci"},
+		{
+			Name:            "build",
+			Run:             "npm run build",
+			Dir:             "web",
+			Env:             map[string]string{"NODE_ENV": "production"},
+			Timeout:         10 * time.Minute,
+			Retries:         2,
+			RetryDelay:      30 * time.Second,
+			ContinueOnError: true,
+		},
+	}
+
+	assert.Equal(t, expected, Settings.Repositories["gitomatically"].Commands, "Plain and structured steps should be parsed")
+
+	Settings = Config{}
+
+	err = os.WriteFile(filePath, []byte(fileContent+"      - name: empty\n"), 0644)
+
+	if err != nil {
+		t.Error("Cannot write temporary config file")
+	}
+
+	err = InitializeConfig(filePath)
+
+	assert.EqualError(t, err, "command empty of gitomatically repository has nothing to run.", "Step without run should return an error")
+}
+
+func TestInitializeConfigHealthcheck(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	sshPath, err := createTempSSH(t.TempDir())
+
+	if err != nil {
+		t.Error("Error creating temp ssh")
+	}
+
+	tests := map[string]HealthcheckConfig{
+		"": {Url: "http://localhost:3000/health"},
+		"must have only one of url, tcp or command.": {Url: "http://localhost:3000/health", Tcp: "localhost:3000"},
+		"body is not a valid regular expression.":    {Url: "http://localhost:3000/health", Body: "("},
+	}
+
+	for expected, healthcheck := range tests {
+		Settings = Config{}
+
+		fileContent := Config{
+			Preference: PreferenceSettings{
+				PrivateKey: sshPath,
+			},
+			Repositories: map[string]RepositoryConfig{
+				"gitomatically": {
+					Url:         "https://github.com/khouwdevin/gitomatically",
+					Clone:       "git@github.com:khouwdevin/gitomatically.git",
+					Branch:      "master",
+					Path:        filepath.Join(t.TempDir(), "gitomatically"),
+					Healthcheck: healthcheck,
+				},
+			},
+		}
+
+		filePath := filepath.Join(t.TempDir(), "config.yaml")
+
+		err = createTempYAMLFile(filePath, fileContent)
+
+		if err != nil {
+			t.Error("Cannot write temporary config file")
+		}
+
+		err = InitializeConfig(filePath)
+
+		if expected != "" {
+			assert.EqualError(t, err, "healthcheck of gitomatically repository "+expected, "Invalid healthcheck should return an error")
+			continue
+		}
+
+		assert.NoError(t, err, "InitializeConfig should not return an error")
+
+		expectedHealthcheck := HealthcheckConfig{
+			Url:      "http://localhost:3000/health",
+			Status:   200,
+			Retries:  3,
+			Interval: 5 * time.Second,
+			Timeout:  10 * time.Second,
+		}
+
+		assert.Equal(t, expectedHealthcheck, Settings.Repositories["gitomatically"].Healthcheck, "Healthcheck defaults should be set")
+	}
+}
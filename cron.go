@@ -3,12 +3,8 @@ package main
 import (
 	"fmt"
 	"log/slog"
-	"os"
-	"os/exec"
-	"strings"
 	"sync"
 
-	"github.com/go-git/go-git/v5"
 	"github.com/khouwdevin/gitomatically/watcher"
 	"github.com/robfig/cron"
 )
@@ -44,14 +40,12 @@ func NewCron() error {
 
 func ChangeCron() error {
 	if Ccron == nil {
-		NewCron()
-		return nil
+		return NewCron()
 	}
 
 	StopCron()
-	NewCron()
 
-	return nil
+	return NewCron()
 }
 
 func StopCron() error {
@@ -76,41 +70,21 @@ func CronController() {
 		return
 	}
 
-	watcher.ControllerGroup.Add(1)
+	CronTicks.Inc()
 
 	slog.Debug("CRON Rerun all config")
 
-	for _, repository := range Settings.Repositories {
-		err := GitPull(repository)
-
-		if err != nil {
-			if err == git.NoErrAlreadyUpToDate {
-				slog.Debug(fmt.Sprintf("CRON %v is up to date, continue to next repository", repository.Url))
-				continue
-			} else {
-				slog.Debug(fmt.Sprintf("CRON Git pull err output %v", err))
-			}
+	for _, repository := range Repositories() {
+		if repository.DeploysTags() {
+			continue
 		}
 
-		for _, command := range repository.Commands {
-			slog.Debug(fmt.Sprintf("CRON Running %v", command))
-
-			arrCommand := strings.Split(command, " ")
+		err := Enqueue(NewJob(repository, PushEvent{Branch: repository.Branch}, "cron", DeployOptions{}))
 
-			cmd := exec.Command(arrCommand[0], arrCommand[1:]...)
-			cmd.Dir = repository.Path
-			cmd.Env = os.Environ()
-
-			_, err := cmd.Output()
-
-			if err != nil {
-				slog.Error("CRON Failed to run command")
-				break
-			}
+		if err != nil {
+			slog.Error(fmt.Sprintf("CRON Enqueue job of %v error %v", repository.Url, err))
 		}
 	}
 
 	slog.Debug("CRON Cron finished")
-
-	watcher.ControllerGroup.Done()
 }
@@ -18,7 +18,7 @@ func defaultConfig(dirPath string) Config {
 				Clone:    "git@github.com:khouwdevin/gitomatically.git",
 				Branch:   "master",
 				Path:     dirPath,
-				Commands: []string{},
+				Commands: []Step{},
 			},
 		},
 	}
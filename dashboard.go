@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed dashboard/index.html public/gitomatically.png
+var dashboardFiles embed.FS
+
+func DashboardController(c *gin.Context) {
+	index, err := dashboardFiles.ReadFile("dashboard/index.html")
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+}
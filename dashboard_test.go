@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboard(t *testing.T) {
+	router := gin.New()
+	router.GET("/dashboard", DashboardController)
+	router.StaticFileFS("/dashboard/gitomatically.png", "public/gitomatically.png", http.FS(dashboardFiles))
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/dashboard", nil))
+
+	assert.Equal(t, http.StatusOK, res.Code, "Dashboard should return 200")
+	assert.Contains(t, res.Header().Get("Content-Type"), "text/html", "Dashboard should be HTML")
+	assert.Contains(t, res.Body.String(), "<title>Gitomatically</title>", "Dashboard should be the embedded page")
+
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/dashboard/gitomatically.png", nil))
+
+	assert.Equal(t, http.StatusOK, res.Code, "Logo should return 200")
+	assert.Equal(t, "image/png", res.Header().Get("Content-Type"), "Logo should be a PNG")
+}
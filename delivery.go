@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+type DeliveryStore struct {
+	mutex sync.Mutex
+	path  string
+	limit int
+	keys  []string
+	seen  map[string]struct{}
+}
+
+var (
+	ErrDuplicateDelivery = errors.New("delivery is already processed")
+
+	Deliveries *DeliveryStore
+
+	deliveryHeaders = []string{"X-GitHub-Delivery", "X-Gitea-Delivery", "X-Gitlab-Event-UUID", "X-Request-UUID"}
+)
+
+func InitializeDeliveries() error {
+	store, err := NewDeliveryStore(filepath.Join(Settings.Preference.DataDir, "deliveries.json"), Settings.Preference.DeliveryHistory)
+
+	if err != nil {
+		return err
+	}
+
+	Deliveries = store
+
+	return nil
+}
+
+func NewDeliveryStore(path string, limit int) (*DeliveryStore, error) {
+	store := &DeliveryStore{
+		path:  path,
+		limit: limit,
+		seen:  map[string]struct{}{},
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		err = json.Unmarshal(data, &store.keys)
+
+		if err != nil {
+			return nil, fmt.Errorf("read deliveries %v error %w", path, err)
+		}
+	}
+
+	if len(store.keys) > limit {
+		store.keys = store.keys[len(store.keys)-limit:]
+	}
+
+	for _, key := range store.keys {
+		store.seen[key] = struct{}{}
+	}
+
+	return store, nil
+}
+
+// DeliveryKeys returns the delivery id sent by the provider and the digest of the body,
+// the body is signed so the digest also catches replays with a forged delivery id.
+// Generic hooks are redeployed with the same body on purpose, so only their delivery id is used.
+func DeliveryKeys(provider Provider, r *http.Request, body []byte) []string {
+	var keys []string
+
+	for _, header := range deliveryHeaders {
+		if id := r.Header.Get(header); id != "" {
+			keys = append(keys, fmt.Sprintf("id:%v", id))
+			break
+		}
+	}
+
+	if provider.Name() == (GenericProvider{}).Name() {
+		return keys
+	}
+
+	digest := sha256.Sum256(body)
+	keys = append(keys, fmt.Sprintf("sha256:%v", hex.EncodeToString(digest[:])))
+
+	return keys
+}
+
+// Add records the keys of a delivery and returns ErrDuplicateDelivery if one of them is already recorded
+func (s *DeliveryStore) Add(keys []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, key := range keys {
+		if _, ok := s.seen[key]; ok {
+			return ErrDuplicateDelivery
+		}
+	}
+
+	for _, key := range keys {
+		s.keys = append(s.keys, key)
+		s.seen[key] = struct{}{}
+	}
+
+	for len(s.keys) > s.limit {
+		delete(s.seen, s.keys[0])
+		s.keys = s.keys[1:]
+	}
+
+	return s.save()
+}
+
+// Remove forgets the keys of a delivery that was not processed, so the retry of the provider is accepted
+func (s *DeliveryStore) Remove(keys []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keys = slices.DeleteFunc(s.keys, func(key string) bool {
+		return slices.Contains(keys, key)
+	})
+
+	for _, key := range keys {
+		delete(s.seen, key)
+	}
+
+	return s.save()
+}
+
+func (s *DeliveryStore) save() error {
+	data, err := json.Marshal(s.keys)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.path), 0755)
+
+	if err != nil {
+		return err
+	}
+
+	tempPath := fmt.Sprintf("%v.tmp", s.path)
+
+	err = os.WriteFile(tempPath, data, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	slog.Debug(fmt.Sprintf("DELIVERY Saved %v delivery keys", len(s.keys)))
+
+	return os.Rename(tempPath, s.path)
+}
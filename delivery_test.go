@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryKeys(t *testing.T) {
+	req := httptest.NewRequest("POST", "/webhook", nil)
+	req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+
+	keys := DeliveryKeys(GithubProvider{}, req, []byte("{}"))
+
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "id:72d3162e-cc78-11e3-81ab-4c9367dc0958", keys[0])
+	assert.Contains(t, keys[1], "sha256:")
+
+	req = httptest.NewRequest("POST", "/webhook", nil)
+
+	assert.Equal(t, keys[1:], DeliveryKeys(GithubProvider{}, req, []byte("{}")))
+
+	assert.Empty(t, DeliveryKeys(GenericProvider{}, req, []byte("{}")), "Generic hooks should not be deduplicated by body")
+
+	req.Header.Set("X-Request-UUID", "1")
+
+	assert.Equal(t, []string{"id:1"}, DeliveryKeys(GenericProvider{}, req, []byte("{}")), "Generic hooks should be deduplicated by delivery id")
+}
+
+func TestDeliveryStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deliveries.json")
+
+	store, err := NewDeliveryStore(path, 4)
+
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add([]string{"id:1", "sha256:a"}))
+	assert.ErrorIs(t, store.Add([]string{"id:1", "sha256:b"}), ErrDuplicateDelivery)
+	assert.ErrorIs(t, store.Add([]string{"id:2", "sha256:a"}), ErrDuplicateDelivery)
+	assert.NoError(t, store.Add([]string{"id:2", "sha256:b"}))
+
+	reloaded, err := NewDeliveryStore(path, 4)
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, reloaded.Add([]string{"id:2"}), ErrDuplicateDelivery)
+
+	// The oldest delivery is evicted once the limit is reached
+	assert.NoError(t, reloaded.Add([]string{"id:3", "sha256:c"}))
+	assert.NoError(t, reloaded.Add([]string{"id:1"}))
+
+	assert.NoError(t, reloaded.Remove([]string{"id:1"}))
+	assert.NoError(t, reloaded.Add([]string{"id:1"}), "Removed delivery should be accepted again")
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+)
+
+func BranchFromRef(ref string) (string, bool) {
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(ref, "refs/heads/"), true
+}
+
+func TagFromRef(ref string) (string, bool) {
+	if !strings.HasPrefix(ref, "refs/tags/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(ref, "refs/tags/"), true
+}
+
+func FindRepositories(event PushEvent) []RepositoryConfig {
+	var repositories []RepositoryConfig
+
+	for name, repository := range Settings.Repositories {
+		repository.Name = name
+
+		if repository.Provider == event.Provider && repository.Url == event.RepositoryUrl && repository.MatchesRef(event) {
+			repositories = append(repositories, repository.ForEvent(event))
+		}
+	}
+
+	return repositories
+}
+
+// commandKillDelay is how long a cancelled command can take to stop before it is killed
+const commandKillDelay = 10 * time.Second
+
+type DeployOptions struct {
+	// Sha resets the worktree to the commit after pulling, even if the branch is already up to date
+	Sha string `json:"sha,omitempty"`
+	// Force runs the commands even if the repository is already up to date
+	Force bool `json:"force,omitempty"`
+	// SkipPull runs the commands on the current worktree
+	SkipPull bool `json:"skip_pull,omitempty"`
+}
+
+// StepError is the failure of a step, its output is passed to the on_failure hooks
+type StepError struct {
+	Step   Step
+	Output string
+	Err    error
+}
+
+func (e *StepError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+type CommandResult struct {
+	Name       string    `json:"name,omitempty"`
+	Command    string    `json:"command"`
+	Attempt    int       `json:"attempt,omitempty"`
+	ExitCode   int       `json:"exit_code"`
+	DurationMs int64     `json:"duration_ms"`
+	Output     string    `json:"output"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+// commandOutputLimit is how many bytes of the end of a command output are kept in the history
+const commandOutputLimit = 64 * 1024
+
+func DeployRepository(ctx context.Context, job *Job) error {
+	// Branch patterns are not cloned on startup, so a pattern with its own path is cloned by its first push
+	if _, err := os.Stat(filepath.Join(job.Config.Path, ".git")); os.IsNotExist(err) && !job.Options.SkipPull {
+		return cloneRepository(ctx, job)
+	}
+
+	// The pull hooks would stop the app for nothing when there is nothing to pull, and a commit that was rolled back
+	// is only deployed again when it is forced or requested by its sha
+	if !job.Options.SkipPull && !job.Options.Force && job.Options.Sha == "" && (len(job.Config.PrePull) > 0 || job.Config.Healthcheck.Enabled()) {
+		remoteSha, err := GitRemoteSha(job.Config)
+
+		if err != nil {
+			return err
+		}
+
+		if sha, _ := HeadSha(job.Config.Path); sha == remoteSha {
+			return git.NoErrAlreadyUpToDate
+		}
+
+		if job.Config.Healthcheck.Enabled() && IsRolledBack(job.Path, remoteSha) {
+			job.Logs().Write("system", fmt.Sprintf("%v was rolled back after failing its health check, force the deployment to deploy it again", remoteSha))
+			return git.NoErrAlreadyUpToDate
+		}
+	}
+
+	var pull func() error
+
+	if !job.Options.SkipPull || job.Options.Sha != "" {
+		pull = func() error {
+			if !job.Options.SkipPull {
+				err := GitPull(job.Config)
+
+				if err != nil && !((job.Options.Force || job.Options.Sha != "") && err == git.NoErrAlreadyUpToDate) {
+					return err
+				}
+			}
+
+			if job.Options.Sha != "" {
+				return GitReset(job.Config, job.Options.Sha)
+			}
+
+			return nil
+		}
+	}
+
+	return DeployWithHooks(ctx, job, job.Config, nil, pull)
+}
+
+// cloneRepository deploys a repository that is not cloned yet, there is no worktree for the pre_pull hooks before the
+// clone
+func cloneRepository(ctx context.Context, job *Job) error {
+	slog.Info(fmt.Sprintf("DEPLOY Cloning %v into %v", job.Config.Url, job.Config.Path))
+
+	clone := job.Config
+	clone.PrePull = nil
+
+	return DeployWithHooks(ctx, job, clone, nil, func() error {
+		err := GitClone(job.Config)
+
+		if err != nil || job.Options.Sha == "" {
+			return err
+		}
+
+		return GitReset(job.Config, job.Options.Sha)
+	})
+}
+
+func DeployTag(ctx context.Context, job *Job) error {
+	// A tag push and its release deliver the same tag, pre_pull must not stop the app when the tag is already deployed
+	if !job.Options.Force && len(job.Config.PrePull) > 0 {
+		checkedOut, err := GitTagCheckedOut(job.Config, job.Event.Tag)
+
+		if err != nil {
+			return err
+		}
+
+		if checkedOut {
+			return git.NoErrAlreadyUpToDate
+		}
+	}
+
+	pull := func() error {
+		err := GitCheckoutTag(job.Config, job.Event.Tag)
+
+		if err != nil && !(job.Options.Force && err == git.NoErrAlreadyUpToDate) {
+			return err
+		}
+
+		return nil
+	}
+
+	return DeployWithHooks(ctx, job, job.Config, []string{fmt.Sprintf("GITOMATICALLY_TAG=%v", job.Event.Tag)}, pull)
+}
+
+// DeployWithHooks updates the worktree with pull and runs the commands between the lifecycle hooks of the repository.
+// The pull hooks are skipped without pull, and no hook runs when the repository is up to date before pre_pull.
+func DeployWithHooks(ctx context.Context, job *Job, repository RepositoryConfig, env []string, pull func() error) error {
+	err := deploySteps(ctx, job, repository, env, pull)
+
+	if err == git.NoErrAlreadyUpToDate && len(repository.PrePull) == 0 {
+		return err
+	}
+
+	runFinishHooks(ctx, job, repository, env, err)
+
+	return err
+}
+
+func deploySteps(ctx context.Context, job *Job, repository RepositoryConfig, env []string, pull func() error) error {
+	if pull != nil {
+		err := RunHooks(ctx, job, repository, "pre_pull", repository.PrePull, env)
+
+		if err != nil {
+			return err
+		}
+
+		err = pull()
+
+		if err == git.NoErrAlreadyUpToDate {
+			return err
+		}
+
+		if err != nil {
+			return &StepError{Step: Step{Name: "pull"}, Output: err.Error(), Err: err}
+		}
+
+		err = RunHooks(ctx, job, repository, "post_pull", repository.PostPull, env)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := RunCommands(ctx, job, repository, env)
+
+	if err != nil {
+		return err
+	}
+
+	return verifyDeployment(ctx, job, repository, env)
+}
+
+// runFinishHooks runs on_success or on_failure and then always. They also run for cancelled deployments, and their
+// failures are logged without changing the result of the deployment.
+func runFinishHooks(ctx context.Context, job *Job, repository RepositoryConfig, env []string, err error) {
+	status := JobSucceeded
+
+	var rollback *RollbackError
+
+	if err == git.NoErrAlreadyUpToDate {
+		status = JobUpToDate
+	} else if errors.As(err, &rollback) {
+		status = JobRolledBack
+	} else if err != nil && ctx.Err() != nil {
+		status = JobCancelled
+	} else if err != nil {
+		status = JobFailed
+	}
+
+	env = append(slices.Clone(env), fmt.Sprintf("GITOMATICALLY_STATUS=%v", status))
+
+	if status == JobFailed || status == JobCancelled || status == JobRolledBack {
+		step, output := "", err.Error()
+
+		var stepErr *StepError
+
+		if errors.As(err, &stepErr) {
+			step, output = stepErr.Step.String(), stepErr.Output
+		}
+
+		env = append(env,
+			fmt.Sprintf("GITOMATICALLY_ERROR=%v", err),
+			fmt.Sprintf("GITOMATICALLY_FAILED_STEP=%v", step),
+			fmt.Sprintf("GITOMATICALLY_FAILED_OUTPUT=%v", strings.TrimRight(output, "\n")),
+		)
+	}
+
+	ctx = context.WithoutCancel(ctx)
+
+	switch status {
+	case JobSucceeded:
+		RunHooks(ctx, job, repository, "on_success", repository.OnSuccess, env)
+	case JobFailed, JobCancelled, JobRolledBack:
+		RunHooks(ctx, job, repository, "on_failure", repository.OnFailure, env)
+	}
+
+	RunHooks(ctx, job, repository, "always", repository.Always, env)
+}
+
+// RunHooks runs the steps of a lifecycle hook
+func RunHooks(ctx context.Context, job *Job, repository RepositoryConfig, name string, steps []Step, env []string) error {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	job.Logs().Write("system", fmt.Sprintf("Running %v hooks", name))
+
+	err := RunSteps(ctx, job, repository, steps, env)
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("DEPLOY %v hook of %v failed %v", name, job.Repository, err))
+	}
+
+	return err
+}
+
+func DeployPreview(ctx context.Context, job *Job) error {
+	preview := job.Config
+	preview.Path = job.Worktree()
+	preview.Commands = job.Config.PreviewCommands
+
+	err := GitCheckoutPullRequest(preview, job.Event.PullRequest, job.Event.After)
+
+	if err != nil {
+		return err
+	}
+
+	return RunCommands(ctx, job, preview, []string{
+		fmt.Sprintf("GITOMATICALLY_PR=%v", job.Event.PullRequest),
+		fmt.Sprintf("GITOMATICALLY_PR_SHA=%v", job.Event.After),
+	})
+}
+
+func TeardownPreview(ctx context.Context, job *Job) error {
+	preview := job.Config
+	preview.Path = job.Worktree()
+	preview.Commands = job.Config.TeardownCommands
+
+	_, err := os.Stat(preview.Path)
+
+	if os.IsNotExist(err) {
+		slog.Debug(fmt.Sprintf("DEPLOY Preview %v does not exist, skip teardown", preview.Path))
+		return nil
+	}
+
+	// The directory is kept when teardown fails so it can be cleaned up by hand
+	err = RunCommands(ctx, job, preview, []string{fmt.Sprintf("GITOMATICALLY_PR=%v", job.Event.PullRequest)})
+
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(preview.Path)
+}
+
+// DeploymentEnv returns the variables describing the deployment to its commands
+func DeploymentEnv(job *Job, repositoryPath string) []string {
+	branch := job.Event.Branch
+
+	if branch == "" {
+		branch = job.Config.Branch
+	}
+
+	var sha, message string
+
+	commit, err := HeadCommit(repositoryPath)
+
+	if err == nil {
+		sha = commit.Hash.String()
+		message = strings.TrimSpace(commit.Message)
+	}
+
+	return []string{
+		fmt.Sprintf("GITOMATICALLY_REPO=%v", job.Repository),
+		fmt.Sprintf("GITOMATICALLY_BRANCH=%v", branch),
+		fmt.Sprintf("GITOMATICALLY_PREVIOUS_SHA=%v", job.PreviousSha),
+		fmt.Sprintf("GITOMATICALLY_SHA=%v", sha),
+		fmt.Sprintf("GITOMATICALLY_COMMIT_MESSAGE=%v", message),
+		fmt.Sprintf("GITOMATICALLY_PUSHER=%v", job.Event.Pusher),
+		fmt.Sprintf("GITOMATICALLY_TRIGGER=%v", job.Trigger),
+		fmt.Sprintf("GITOMATICALLY_JOB_ID=%v", job.Id),
+	}
+}
+
+// RunCommands runs the commands of the repository one by one and records their results in the job
+func RunCommands(ctx context.Context, job *Job, repository RepositoryConfig, env []string) error {
+	return RunSteps(ctx, job, repository, repository.Commands, env)
+}
+
+func RunSteps(ctx context.Context, job *Job, repository RepositoryConfig, steps []Step, env []string) error {
+	env = append(DeploymentEnv(job, repository.Path), env...)
+
+	for _, step := range steps {
+		err := RunStep(ctx, job, repository, step, env)
+
+		if err != nil && ctx.Err() == nil && step.ContinueOnError {
+			slog.Warn(fmt.Sprintf("DEPLOY %v, continue on error", err))
+			job.Logs().Write("system", fmt.Sprintf("%v, continue on error", err))
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunStep runs the step until it succeeds or it fails more often than it is retried
+func RunStep(ctx context.Context, job *Job, repository RepositoryConfig, step Step, env []string) error {
+	var err error
+
+	for attempt := 1; attempt <= step.Retries+1; attempt++ {
+		if attempt > 1 {
+			slog.Debug(fmt.Sprintf("DEPLOY Retry %v attempt %v", step, attempt))
+			job.Logs().Write("system", fmt.Sprintf("Retrying in %v, attempt %v of %v", step.RetryDelay, attempt, step.Retries+1))
+
+			select {
+			case <-ctx.Done():
+				return &StepError{Step: step, Err: fmt.Errorf("command %v is cancelled: %w", step, ctx.Err())}
+			case <-time.After(step.RetryDelay):
+			}
+		}
+
+		err = runAttempt(ctx, job, repository, step, env, attempt)
+
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func runAttempt(ctx context.Context, job *Job, repository RepositoryConfig, step Step, env []string, attempt int) error {
+	stepCtx := ctx
+
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		stepCtx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+
+	slog.Debug(fmt.Sprintf("DEPLOY Running %v", step.Run))
+
+	cmd, err := NewCommand(stepCtx, repository, step, env)
+
+	if err != nil {
+		return &StepError{Step: step, Err: fmt.Errorf("failed to run command %v: %w", step, err)}
+	}
+
+	output := &commandOutput{}
+	stdout := &streamWriter{stream: "stdout", logs: job.Logs(), output: output}
+	stderr := &streamWriter{stream: "stderr", logs: job.Logs(), output: output}
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	job.Logs().Write("system", fmt.Sprintf("$ %v", step.Run))
+
+	result := CommandResult{Name: step.Name, Command: step.Run, Attempt: attempt, StartedAt: time.Now()}
+
+	err = cmd.Run()
+
+	stdout.Flush()
+	stderr.Flush()
+
+	result.DurationMs = time.Since(result.StartedAt).Milliseconds()
+	ObserveDuration(CommandDuration, result.StartedAt, repository.Name, step.String())
+	result.ExitCode = cmd.ProcessState.ExitCode()
+	result.Output = output.String()
+
+	job.Logs().Write("system", fmt.Sprintf("exit code %v in %v ms", result.ExitCode, result.DurationMs))
+
+	job.AddCommand(result)
+
+	if ctx.Err() != nil {
+		return &StepError{Step: step, Output: result.Output, Err: fmt.Errorf("command %v is cancelled: %w", step, ctx.Err())}
+	}
+
+	if stepCtx.Err() == context.DeadlineExceeded {
+		return &StepError{Step: step, Output: result.Output, Err: fmt.Errorf("command %v timed out after %v", step, step.Timeout)}
+	}
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("DEPLOY Command err output %v", result.Output))
+		return &StepError{Step: step, Output: result.Output, Err: fmt.Errorf("failed to run command %v: %w", step, err)}
+	}
+
+	return nil
+}
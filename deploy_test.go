@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewLifecycle(t *testing.T) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.PreviewCommands = []Step{{Run: "touch preview.txt"}}
+	repository.TeardownCommands = []Step{{Run: "rm preview.txt"}}
+
+	remote.Checkout("feature", true)
+	hash := remote.Commit("feature.go")
+	remote.Checkout("master", false)
+
+	err := remote.Repository.Storer.SetReference(plumbing.NewHashReference("refs/pull/1/head", hash))
+
+	if err != nil {
+		t.Fatalf("Error creating pull request reference %v", err)
+	}
+
+	event := PushEvent{Branch: "master", After: hash.String(), PullRequest: 1, Action: "opened"}
+	previewPath := repository.PreviewDirectory(1)
+
+	assert.Equal(t, filepath.Join(repository.Path+"-previews", "pr-1"), previewPath, "Preview directory should be next to the repository path")
+
+	err = DeployPreview(context.Background(), NewJob(repository, event, "webhook", DeployOptions{}))
+
+	assert.NoError(t, err, "Deploy preview should not return an error")
+	assert.Equal(t, hash, headHash(t, previewPath), "Preview should checkout the pull request head")
+	assert.FileExists(t, filepath.Join(previewPath, "feature.go"), "Preview should contain the pull request files")
+	assert.FileExists(t, filepath.Join(previewPath, "preview.txt"), "Preview commands should run in the preview directory")
+
+	event.Action = "closed"
+
+	err = TeardownPreview(context.Background(), NewJob(repository, event, "webhook", DeployOptions{}))
+
+	assert.NoError(t, err, "Teardown preview should not return an error")
+	assert.NoDirExists(t, previewPath, "Preview directory should be removed")
+}
+
+func TestRunCommandsSteps(t *testing.T) {
+	dirPath := t.TempDir()
+
+	err := os.Mkdir(filepath.Join(dirPath, "web"), 0755)
+
+	if err != nil {
+		t.Fatalf("Error creating directory %v", err)
+	}
+
+	repository := RepositoryConfig{
+		Path: dirPath,
+		Commands: []Step{
+			{Name: "env", Run: "touch $TARGET", Dir: "web", Env: map[string]string{"TARGET": "built.txt"}},
+			{Name: "flaky", Run: "ls missing.txt", Retries: 2, RetryDelay: 10 * time.Millisecond, ContinueOnError: true},
+			{Run: "touch after.txt"},
+		},
+	}
+
+	job := NewJob(repository, PushEvent{}, "manual", DeployOptions{})
+
+	err = RunCommands(context.Background(), job, repository, nil)
+
+	assert.NoError(t, err, "Failed step with continue_on_error should not return an error")
+	assert.FileExists(t, filepath.Join(dirPath, "web", "built.txt"), "Step should run in its dir with its env")
+	assert.FileExists(t, filepath.Join(dirPath, "after.txt"), "Steps after a failed step with continue_on_error should run")
+	assert.Len(t, job.Commands, 5, "Every attempt should be recorded")
+	assert.Equal(t, "flaky", job.Commands[3].Name, "Attempt should record the step name")
+	assert.Equal(t, 3, job.Commands[3].Attempt, "Failed step should be retried")
+}
+
+func TestRunCommandsStepTimeout(t *testing.T) {
+	dirPath := t.TempDir()
+
+	repository := RepositoryConfig{
+		Path: dirPath,
+		Commands: []Step{
+			{Run: "sleep 5", Timeout: 100 * time.Millisecond},
+			{Run: "touch after.txt"},
+		},
+	}
+
+	start := time.Now()
+
+	err := RunCommands(context.Background(), NewJob(repository, PushEvent{}, "manual", DeployOptions{}), repository, nil)
+
+	assert.EqualError(t, err, "command sleep 5 timed out after 100ms", "Step should time out")
+	assert.Less(t, time.Since(start), 3*time.Second, "Step should be stopped at its timeout")
+	assert.NoFileExists(t, filepath.Join(dirPath, "after.txt"), "Steps after a timed out step should not run")
+}
+
+func TestDeploymentEnv(t *testing.T) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Name = "gitomatically"
+	repository.Commands = []Step{{Run: `sh -c "env > env.txt"`}}
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	job := NewJob(repository, PushEvent{Branch: "master", Pusher: "khouwdevin"}, "webhook", DeployOptions{})
+	job.PreviousSha = "0123456789abcdef"
+
+	err = RunCommands(context.Background(), job, repository, nil)
+
+	assert.NoError(t, err, "RunCommands should not return an error")
+
+	data, err := os.ReadFile(filepath.Join(repository.Path, "env.txt"))
+
+	if err != nil {
+		t.Fatalf("Error reading env %v", err)
+	}
+
+	commit, err := HeadCommit(repository.Path)
+
+	if err != nil {
+		t.Fatalf("Error reading head commit %v", err)
+	}
+
+	env := strings.Split(string(data), "\n")
+
+	for _, variable := range []string{
+		"GITOMATICALLY_REPO=gitomatically",
+		"GITOMATICALLY_BRANCH=master",
+		"GITOMATICALLY_PREVIOUS_SHA=0123456789abcdef",
+		fmt.Sprintf("GITOMATICALLY_SHA=%v", headHash(t, repository.Path)),
+		fmt.Sprintf("GITOMATICALLY_COMMIT_MESSAGE=%v", strings.TrimSpace(commit.Message)),
+		"GITOMATICALLY_PUSHER=khouwdevin",
+		"GITOMATICALLY_TRIGGER=webhook",
+		fmt.Sprintf("GITOMATICALLY_JOB_ID=%v", job.Id),
+	} {
+		assert.Contains(t, env, variable, "Commands should get %v", variable)
+	}
+}
+
+func hookStep(name string) Step {
+	return Step{Run: fmt.Sprintf(`sh -c "echo %v $GITOMATICALLY_STATUS >> $HOOK_LOG"`, name)}
+}
+
+func readHookLog(t *testing.T, logPath string) []string {
+	data, err := os.ReadFile(logPath)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		t.Fatalf("Error reading hook log %v", err)
+	}
+
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestDeployRepositorySha(t *testing.T) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Commands = []Step{{Run: "touch deployed.txt"}}
+
+	previousHash := remote.Commit("main.go")
+	remote.Commit("utils.go")
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	err = DeployRepository(context.Background(), NewJob(repository, PushEvent{Branch: "master"}, "manual", DeployOptions{Sha: previousHash.String()}))
+
+	assert.NoError(t, err, "Deploy of a sha should not return an error when the branch is up to date")
+	assert.Equal(t, previousHash, headHash(t, repository.Path), "Deploy should reset the worktree to the sha")
+	assert.FileExists(t, filepath.Join(repository.Path, "deployed.txt"), "Deploy should run the commands")
+}
+
+func TestDeployRepositoryClonesPatternPath(t *testing.T) {
+	remote := createTempRemote(t)
+	remote.Checkout("release/1.0", true)
+	hash := remote.Commit("release.go")
+
+	repository := tempRepositoryConfig(t, remote)
+	repository.Branch = ""
+	repository.Branches = []BranchConfig{{Name: "release/*", Path: filepath.Join(t.TempDir(), "production")}}
+	repository.Commands = []Step{{Run: "touch deployed.txt"}}
+
+	release, ok := repository.ForBranch("release/1.0")
+
+	if !ok {
+		t.Fatalf("Branch pattern should match release/1.0")
+	}
+
+	err := DeployRepository(context.Background(), NewJob(release, PushEvent{Branch: "release/1.0"}, "webhook", DeployOptions{}))
+
+	assert.NoError(t, err, "Deploy should clone the path of the branch pattern")
+	assert.Equal(t, hash, headHash(t, release.Path), "Deploy should check out the pushed branch")
+	assert.FileExists(t, filepath.Join(release.Path, "deployed.txt"), "Deploy should run the commands after the clone")
+}
+
+func TestDeployHooks(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "hooks.log")
+	t.Setenv("HOOK_LOG", logPath)
+
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.PrePull = []Step{hookStep("pre_pull")}
+	repository.PostPull = []Step{hookStep("post_pull")}
+	repository.Commands = []Step{hookStep("command")}
+	repository.OnSuccess = []Step{hookStep("on_success")}
+	repository.OnFailure = []Step{hookStep("on_failure")}
+	repository.Always = []Step{hookStep("always")}
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	err = DeployRepository(context.Background(), NewJob(repository, PushEvent{Branch: "master"}, "cron", DeployOptions{}))
+
+	assert.Equal(t, git.NoErrAlreadyUpToDate, err, "Deploy should return already up to date without new commits")
+	assert.Empty(t, readHookLog(t, logPath), "Hooks should not run when the repository is up to date")
+
+	remote.Commit("main.go")
+
+	err = DeployRepository(context.Background(), NewJob(repository, PushEvent{Branch: "master"}, "cron", DeployOptions{}))
+
+	assert.NoError(t, err, "Deploy should not return an error")
+	assert.Equal(t, []string{"pre_pull", "post_pull", "command", "on_success succeeded", "always succeeded"}, readHookLog(t, logPath), "Hooks should run around the pull and the commands")
+}
+
+func TestDeployTagHooksUpToDate(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "hooks.log")
+	t.Setenv("HOOK_LOG", logPath)
+
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Branch = ""
+	repository.DeployOn = "tags"
+	repository.TagPattern = "v*"
+	repository.PrePull = []Step{hookStep("pre_pull")}
+	repository.Commands = []Step{hookStep("command")}
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	_, err = remote.Repository.CreateTag("v1.0.0", remote.Commit("main.go"), nil)
+
+	if err != nil {
+		t.Fatalf("Create tag error %v", err)
+	}
+
+	err = DeployTag(context.Background(), NewJob(repository, PushEvent{Tag: "v1.0.0"}, "webhook", DeployOptions{}))
+
+	assert.NoError(t, err, "Deploy tag should not return an error")
+	assert.Equal(t, []string{"pre_pull", "command"}, readHookLog(t, logPath), "Hooks should run around the checkout of the tag")
+
+	// GitHub delivers a tag push and a published release for the same tag
+	err = DeployTag(context.Background(), NewJob(repository, PushEvent{Tag: "v1.0.0"}, "webhook", DeployOptions{}))
+
+	assert.Equal(t, git.NoErrAlreadyUpToDate, err, "Deployed tag should be up to date")
+	assert.Equal(t, []string{"pre_pull", "command"}, readHookLog(t, logPath), "pre_pull should not run when the tag is already deployed")
+}
+
+func TestDeployHooksFailure(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "hooks.log")
+	t.Setenv("HOOK_LOG", logPath)
+
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Commands = []Step{{Name: "check", Run: "ls missing-file"}, hookStep("command")}
+	repository.OnSuccess = []Step{hookStep("on_success")}
+	repository.OnFailure = []Step{{Run: `sh -c 'echo "$GITOMATICALLY_FAILED_STEP: $GITOMATICALLY_FAILED_OUTPUT" >> "$HOOK_LOG"'`}}
+	repository.Always = []Step{hookStep("always")}
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	remote.Commit("main.go")
+
+	err = DeployRepository(context.Background(), NewJob(repository, PushEvent{Branch: "master"}, "webhook", DeployOptions{}))
+
+	assert.Error(t, err, "Deploy should return the error of the failed command")
+
+	log := readHookLog(t, logPath)
+
+	if assert.Len(t, log, 2, "Only on_failure and always should run") {
+		assert.True(t, strings.HasPrefix(log[0], "check: "), "on_failure should get the failed step name")
+		assert.Contains(t, log[0], "missing-file", "on_failure should get the failed step output")
+		assert.Equal(t, "always failed", log[1], "always should get the status")
+	}
+}
+
+func TestPreStartHooks(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "hooks.log")
+	t.Setenv("HOOK_LOG", logPath)
+
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.PrePull = []Step{hookStep("pre_pull")}
+	repository.PostPull = []Step{hookStep("post_pull")}
+	repository.Always = []Step{hookStep("always")}
+
+	Settings.Repositories = map[string]RepositoryConfig{"gitomatically": repository}
+
+	err := PreStart()
+
+	assert.NoError(t, err, "PreStart should not return an error")
+	assert.Equal(t, []string{"post_pull", "always succeeded"}, readHookLog(t, logPath), "pre_pull should not run before a clone")
+
+	remote.Commit("main.go")
+	os.Remove(logPath)
+
+	err = PreStart()
+
+	assert.NoError(t, err, "PreStart should not return an error")
+	assert.Equal(t, []string{"pre_pull", "post_pull", "always succeeded"}, readHookLog(t, logPath), "Hooks should run around the pull")
+}
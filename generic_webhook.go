@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GenericRequest struct {
+	Ref string `json:"ref"`
+	Sha string `json:"sha"`
+}
+
+// GenericProvider authenticates deliveries for a single repository with the
+// credentials of its hook config, so it is created per request instead of registered.
+type GenericProvider struct {
+	RepositoryName string
+	Repository     RepositoryConfig
+}
+
+func (GenericProvider) Name() string {
+	return "generic"
+}
+
+func (p GenericProvider) Verify(r *http.Request, body []byte) error {
+	hook := p.Repository.Hook
+
+	authorization := r.Header.Get("Authorization")
+
+	if hook.Token != "" && strings.HasPrefix(authorization, "Bearer ") {
+		token := strings.TrimPrefix(authorization, "Bearer ")
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(hook.Token)) != 1 {
+			return ErrInvalidSignature
+		}
+
+		return nil
+	}
+
+	if hook.Secret != "" {
+		signatureHeader := r.Header.Get(hook.SignatureHeader())
+
+		if signatureHeader == "" {
+			return MissingHeaderError{Header: hook.SignatureHeader()}
+		}
+
+		if !ValidSignature(hook.Secret, body, strings.TrimPrefix(signatureHeader, "sha256=")) {
+			return ErrInvalidSignature
+		}
+
+		return nil
+	}
+
+	return MissingHeaderError{Header: "Authorization"}
+}
+
+func (p GenericProvider) Parse(r *http.Request, body []byte) ([]PushEvent, error) {
+	var request GenericRequest
+
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+	}
+
+	// Any revision would be resolved by the reset, so only commit hashes are accepted like in the API
+	if request.Sha != "" && !shaPattern.MatchString(request.Sha) {
+		return nil, fmt.Errorf("sha %v is not a hexadecimal commit hash", request.Sha)
+	}
+
+	event := PushEvent{
+		Provider:      p.Name(),
+		Event:         "trigger",
+		Repository:    p.RepositoryName,
+		RepositoryUrl: p.Repository.Url,
+		Ref:           request.Ref,
+		After:         request.Sha,
+		Sha:           request.Sha,
+	}
+
+	if tag, ok := TagFromRef(request.Ref); ok {
+		event.Tag = tag
+
+		return []PushEvent{event}, nil
+	}
+
+	// Without ref the first branch that is not a pattern is deployed, like a manual deployment
+	if expanded := p.Repository.Expand(); len(expanded) > 0 {
+		event.Branch = expanded[0].Branch
+	}
+
+	if request.Ref != "" {
+		if branch, ok := BranchFromRef(request.Ref); ok {
+			event.Branch = branch
+		} else {
+			event.Branch = request.Ref
+		}
+	}
+
+	if event.Branch != "" {
+		event.Ref = fmt.Sprintf("refs/heads/%v", event.Branch)
+	}
+
+	return []PushEvent{event}, nil
+}
+
+func GenericAuthorization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		repository, ok := Settings.Repositories[name]
+
+		if !ok || !repository.Hook.Enabled() {
+			slog.Debug(fmt.Sprintf("MIDDLEWARE Hook of %v repository is not found", name))
+
+			c.JSON(http.StatusNotFound, gin.H{"message": "Repository is not found!"})
+			c.Abort()
+
+			return
+		}
+
+		authorize(c, GenericProvider{RepositoryName: name, Repository: repository})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func genericProvider() GenericProvider {
+	return GenericProvider{
+		RepositoryName: "gitomatically",
+		Repository: RepositoryConfig{
+			Provider: "github",
+			Url:      "https://github.com/khouwdevin/gitomatically",
+			Branch:   "master",
+			Hook: HookConfig{
+				Token:  "helloworld",
+				Secret: "worldhello",
+			},
+		},
+	}
+}
+
+func TestGenericProviderVerifyToken(t *testing.T) {
+	provider := genericProvider()
+
+	req := httptest.NewRequest("POST", "/hooks/gitomatically", nil)
+	req.Header.Set("Authorization", "Bearer helloworld")
+
+	assert.NoError(t, provider.Verify(req, nil), "Verify should accept the configured token")
+
+	req.Header.Set("Authorization", "Bearer worldhello")
+
+	assert.ErrorIs(t, provider.Verify(req, nil), ErrInvalidSignature, "Verify should reject a wrong token")
+}
+
+func TestGenericProviderVerifySignature(t *testing.T) {
+	provider := genericProvider()
+	body := []byte(`{"sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"}`)
+
+	mac := hmac.New(sha256.New, []byte("worldhello"))
+	mac.Write(body)
+
+	req := httptest.NewRequest("POST", "/hooks/gitomatically", nil)
+	req.Header.Set("X-Signature-256", fmt.Sprintf("sha256=%v", hex.EncodeToString(mac.Sum(nil))))
+
+	assert.NoError(t, provider.Verify(req, body), "Verify should accept a valid signature")
+	assert.ErrorIs(t, provider.Verify(req, []byte("{}")), ErrInvalidSignature, "Verify should reject a signature of another body")
+
+	req.Header.Del("X-Signature-256")
+
+	assert.ErrorAs(t, provider.Verify(req, body), &MissingHeaderError{}, "Verify should reject a missing signature")
+}
+
+func TestGenericProviderParse(t *testing.T) {
+	provider := genericProvider()
+	req := httptest.NewRequest("POST", "/hooks/gitomatically", nil)
+
+	events, err := provider.Parse(req, nil)
+
+	assert.NoError(t, err, "Parse should accept an empty body")
+	assert.Equal(t, "master", events[0].Branch, "Branch should default to the repository branch")
+	assert.Equal(t, "gitomatically", events[0].Repository, "Event should target the repository")
+
+	events, err = provider.Parse(req, []byte(`{"ref": "refs/heads/staging", "sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"}`))
+
+	assert.NoError(t, err, "Parse should not return an error")
+	assert.Equal(t, "staging", events[0].Branch, "Branch should be taken from the ref")
+	assert.Equal(t, "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", events[0].Sha, "Sha should be taken from the body")
+
+	for _, sha := range []string{"HEAD~3", "origin/staging"} {
+		_, err = provider.Parse(req, []byte(fmt.Sprintf(`{"sha": %q}`, sha)))
+
+		assert.Error(t, err, "Parse should reject a revision that is not a commit hash")
+	}
+}
+
+func TestGenericProviderParseBranches(t *testing.T) {
+	provider := genericProvider()
+	provider.Repository.Branch = ""
+	provider.Repository.Branches = []BranchConfig{{Name: "release/*"}, {Name: "staging"}}
+
+	req := httptest.NewRequest("POST", "/hooks/gitomatically", nil)
+
+	events, err := provider.Parse(req, nil)
+
+	assert.NoError(t, err, "Parse should accept an empty body")
+	assert.Equal(t, "staging", events[0].Branch, "Branch should default to the first branch that is not a pattern")
+	assert.Equal(t, "refs/heads/staging", events[0].Ref, "Ref should be the default branch")
+}
+
+func TestGenericWebhookUnmatchedRef(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	Settings = Config{
+		Repositories: map[string]RepositoryConfig{
+			"gitomatically": {
+				Url:      "https://github.com/khouwdevin/gitomatically",
+				Branches: []BranchConfig{{Name: "release/*"}},
+				Hook:     HookConfig{Token: "helloworld"},
+			},
+		},
+	}
+
+	router := gin.New()
+	router.POST("/hooks/:name", GenericAuthorization(), WebhookController)
+
+	for _, body := range []string{"", `{"ref": "refs/heads/master"}`} {
+		req := httptest.NewRequest("POST", "/hooks/gitomatically", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer helloworld")
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Code, "Trigger without a deployed ref should return 422")
+	}
+}
+
+func TestGenericWebhookRepositoryNotFound(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	Settings = Config{
+		Repositories: map[string]RepositoryConfig{
+			"gitomatically": {
+				Url:    "https://github.com/khouwdevin/gitomatically",
+				Branch: "master",
+			},
+		},
+	}
+
+	router := gin.New()
+	router.POST("/hooks/:name", GenericAuthorization(), WebhookController)
+
+	for _, name := range []string{"gitomatically", "unknown"} {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/hooks/%v", name), bytes.NewBufferString("{}"))
+		req.Header.Set("Authorization", "Bearer helloworld")
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		var jsonResponse map[string]any
+
+		err := json.Unmarshal(res.Body.Bytes(), &jsonResponse)
+
+		if err != nil {
+			t.Errorf("Failed to unmarshall response %v", err)
+		}
+
+		assert.Equal(t, "Repository is not found!", jsonResponse["message"], "API response message should return Repository is not found!")
+		assert.Equal(t, http.StatusNotFound, res.Code, "API status should return 404 (not found)")
+	}
+}
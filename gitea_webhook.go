@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type GiteaRepository struct {
+	HtmlUrl string `json:"html_url"`
+}
+
+type GiteaUser struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
+type GiteaCommit struct {
+	Id      string    `json:"id"`
+	Message string    `json:"message"`
+	Author  GiteaUser `json:"author"`
+}
+
+type GiteaResponse struct {
+	Ref        string          `json:"ref"`
+	Before     string          `json:"before"`
+	After      string          `json:"after"`
+	Pusher     GiteaUser       `json:"pusher"`
+	Repository GiteaRepository `json:"repository"`
+	Commits    []GiteaCommit   `json:"commits"`
+}
+
+type GiteaProvider struct{}
+
+func (GiteaProvider) Name() string {
+	return "gitea"
+}
+
+func (GiteaProvider) Verify(r *http.Request, body []byte) error {
+	signatureHeader := r.Header.Get("X-Gitea-Signature")
+
+	if signatureHeader == "" {
+		return MissingHeaderError{Header: "X-Gitea-Signature"}
+	}
+
+	secret := os.Getenv("GITEA_WEBHOOK_SECRET")
+
+	if secret == "" || !ValidSignature(secret, body, signatureHeader) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (p GiteaProvider) Parse(r *http.Request, body []byte) ([]PushEvent, error) {
+	event := r.Header.Get("X-Gitea-Event")
+
+	if event != "push" {
+		return nil, ErrEventIgnored
+	}
+
+	var response GiteaResponse
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	// Gitea sends an all zero after commit when the branch or tag is deleted
+	if strings.Trim(response.After, "0") == "" {
+		return nil, nil
+	}
+
+	branch, _ := BranchFromRef(response.Ref)
+	tag, _ := TagFromRef(response.Ref)
+
+	pushEvent := PushEvent{
+		Provider:      p.Name(),
+		Event:         event,
+		RepositoryUrl: response.Repository.HtmlUrl,
+		Ref:           response.Ref,
+		Branch:        branch,
+		Tag:           tag,
+		Before:        response.Before,
+		After:         response.After,
+		Pusher:        response.Pusher.Username,
+	}
+
+	for _, commit := range response.Commits {
+		pushEvent.Commits = append(pushEvent.Commits, Commit{Id: commit.Id, Message: commit.Message, Author: commit.Author.Name})
+	}
+
+	return []PushEvent{pushEvent}, nil
+}
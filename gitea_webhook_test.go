@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var giteaPayload = `{
+	"ref": "refs/heads/master",
+	"before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+	"after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+	"pusher": {"username": "khouwdevin"},
+	"repository": {"html_url": "https://codeberg.org/khouwdevin/gitomatically"},
+	"commits": [{"id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", "message": "fix: typo", "author": {"name": "Devin"}}]
+}`
+
+func TestGiteaProviderVerify(t *testing.T) {
+	t.Setenv("GITEA_WEBHOOK_SECRET", "helloworld")
+
+	provider := GiteaProvider{}
+
+	mac := hmac.New(sha256.New, []byte("helloworld"))
+	mac.Write([]byte(giteaPayload))
+
+	req := httptest.NewRequest("POST", "/webhook/gitea", nil)
+	req.Header.Set("X-Gitea-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	assert.NoError(t, provider.Verify(req, []byte(giteaPayload)), "Verify should accept a valid signature")
+	assert.ErrorIs(t, provider.Verify(req, []byte("tampered")), ErrInvalidSignature, "Verify should reject a tampered body")
+
+	req.Header.Del("X-Gitea-Signature")
+
+	assert.ErrorAs(t, provider.Verify(req, []byte(giteaPayload)), &MissingHeaderError{}, "Verify should reject a missing signature")
+}
+
+func TestGiteaProviderParse(t *testing.T) {
+	req := httptest.NewRequest("POST", "/webhook/gitea", nil)
+	req.Header.Set("X-Gitea-Event", "push")
+
+	events, err := GiteaProvider{}.Parse(req, []byte(giteaPayload))
+
+	assert.NoError(t, err, "Parse should not return an error")
+	assert.Equal(t, []PushEvent{{
+		Provider:      "gitea",
+		Event:         "push",
+		RepositoryUrl: "https://codeberg.org/khouwdevin/gitomatically",
+		Ref:           "refs/heads/master",
+		Branch:        "master",
+		Before:        "95790bf891e76fee5e1747ab589903a6a1f80f22",
+		After:         "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		Pusher:        "khouwdevin",
+		Commits:       []Commit{{Id: "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", Message: "fix: typo", Author: "Devin"}},
+	}}, events, "Parse should normalize the push payload")
+}
+
+func TestGiteaProviderParseIgnoredEvent(t *testing.T) {
+	req := httptest.NewRequest("POST", "/webhook/gitea", nil)
+	req.Header.Set("X-Gitea-Event", "issues")
+
+	_, err := GiteaProvider{}.Parse(req, []byte(giteaPayload))
+
+	assert.ErrorIs(t, err, ErrEventIgnored, "Parse should ignore non push events")
+}
@@ -1,60 +1,238 @@
 package main
 
 import (
-	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func GithubAuthorization() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		signatureHeader := c.GetHeader("X-Hub-Signature-256")
+type RepositoryStruct struct {
+	HtmlUrl string `json:"html_url"`
+}
+
+type GithubUser struct {
+	Name string `json:"name"`
+}
+
+type GithubCommit struct {
+	Id      string     `json:"id"`
+	Message string     `json:"message"`
+	Author  GithubUser `json:"author"`
+}
+
+type GithubResponse struct {
+	Repository RepositoryStruct `json:"repository"`
+	Ref        string           `json:"ref"`
+	Before     string           `json:"before"`
+	After      string           `json:"after"`
+	Deleted    bool             `json:"deleted"`
+	Pusher     GithubUser       `json:"pusher"`
+	Commits    []GithubCommit   `json:"commits"`
+}
+
+type GithubRelease struct {
+	TagName string `json:"tag_name"`
+}
+
+type GithubSender struct {
+	Login string `json:"login"`
+}
+
+type GithubReleaseResponse struct {
+	Action     string           `json:"action"`
+	Release    GithubRelease    `json:"release"`
+	Repository RepositoryStruct `json:"repository"`
+	Sender     GithubSender     `json:"sender"`
+}
+
+type GithubPullRequestRepo struct {
+	FullName string `json:"full_name"`
+}
+
+type GithubPullRequestRef struct {
+	Ref  string                 `json:"ref"`
+	Sha  string                 `json:"sha"`
+	Repo *GithubPullRequestRepo `json:"repo"`
+}
+
+type GithubPullRequest struct {
+	Head GithubPullRequestRef `json:"head"`
+	Base GithubPullRequestRef `json:"base"`
+}
+
+type GithubPullRequestResponse struct {
+	Action      string            `json:"action"`
+	Number      int               `json:"number"`
+	Before      string            `json:"before"`
+	PullRequest GithubPullRequest `json:"pull_request"`
+	Repository  RepositoryStruct  `json:"repository"`
+	Sender      GithubSender      `json:"sender"`
+}
+
+type GithubPingResponse struct {
+	Zen        string           `json:"zen"`
+	HookId     int              `json:"hook_id"`
+	Repository RepositoryStruct `json:"repository"`
+}
+
+type GithubProvider struct{}
+
+func (GithubProvider) Name() string {
+	return "github"
+}
+
+func (GithubProvider) Verify(r *http.Request, body []byte) error {
+	signatureHeader := r.Header.Get("X-Hub-Signature-256")
+
+	if signatureHeader == "" {
+		return MissingHeaderError{Header: "X-Hub-Signature-256"}
+	}
+
+	if !ValidSignature(os.Getenv("GITHUB_WEBHOOK_SECRET"), body, strings.TrimPrefix(signatureHeader, "sha256=")) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (p GithubProvider) Parse(r *http.Request, body []byte) ([]PushEvent, error) {
+	event := r.Header.Get("X-GitHub-Event")
+
+	if event == "ping" {
+		return p.parsePing(body)
+	}
+
+	if event == "release" {
+		return p.parseRelease(body)
+	}
+
+	if event == "pull_request" {
+		return p.parsePullRequest(body)
+	}
+
+	if event != "push" {
+		return nil, ErrEventIgnored
+	}
+
+	var response GithubResponse
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	if response.Deleted {
+		return nil, nil
+	}
+
+	branch, _ := BranchFromRef(response.Ref)
+	tag, _ := TagFromRef(response.Ref)
+
+	pushEvent := PushEvent{
+		Provider:      p.Name(),
+		Event:         event,
+		RepositoryUrl: response.Repository.HtmlUrl,
+		Ref:           response.Ref,
+		Branch:        branch,
+		Tag:           tag,
+		Before:        response.Before,
+		After:         response.After,
+		Pusher:        response.Pusher.Name,
+	}
 
-		if signatureHeader == "" {
-			slog.Debug("MIDDLEWARE Signature is not found")
+	for _, commit := range response.Commits {
+		pushEvent.Commits = append(pushEvent.Commits, Commit{Id: commit.Id, Message: commit.Message, Author: commit.Author.Name})
+	}
 
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "X-Hub-Signature-256 is not found!"})
-			c.Abort()
+	return []PushEvent{pushEvent}, nil
+}
 
-			return
-		}
+func (p GithubProvider) parsePing(body []byte) ([]PushEvent, error) {
+	var response GithubPingResponse
 
-		expectedSignature := signatureHeader[7:]
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
 
-		bodyBytes, err := io.ReadAll(c.Request.Body)
-		if err != nil {
-			slog.Error(fmt.Sprintf("MIDDLEWARE Error reading body %v", err))
+	slog.Debug(fmt.Sprintf("WEBHOOK Github ping from hook %v %v", response.HookId, response.Zen))
 
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
-			c.Abort()
+	return []PushEvent{{
+		Provider:      p.Name(),
+		Event:         "ping",
+		RepositoryUrl: response.Repository.HtmlUrl,
+	}}, nil
+}
 
-			return
-		}
+func (p GithubProvider) parseRelease(body []byte) ([]PushEvent, error) {
+	var response GithubReleaseResponse
 
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
 
-		mac := hmac.New(sha256.New, []byte(os.Getenv("GITHUB_WEBHOOK_SECRET")))
+	if response.Action != "published" {
+		return nil, ErrEventIgnored
+	}
 
-		mac.Write(bodyBytes)
-		computedSignature := hex.EncodeToString(mac.Sum(nil))
+	return []PushEvent{{
+		Provider:      p.Name(),
+		Event:         "release",
+		RepositoryUrl: response.Repository.HtmlUrl,
+		Ref:           fmt.Sprintf("refs/tags/%v", response.Release.TagName),
+		Tag:           response.Release.TagName,
+		Pusher:        response.Sender.Login,
+	}}, nil
+}
 
-		if !hmac.Equal([]byte(computedSignature), []byte(expectedSignature)) {
-			slog.Debug("MIDDLEWARE Signature is not match")
+func (p GithubProvider) parsePullRequest(body []byte) ([]PushEvent, error) {
+	var response GithubPullRequestResponse
 
-			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized!"})
-			c.Abort()
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
 
-			return
-		}
+	if response.Action != "opened" && response.Action != "reopened" && response.Action != "synchronize" && response.Action != "closed" {
+		return nil, ErrEventIgnored
+	}
 
-		c.Next()
+	head, base := response.PullRequest.Head.Repo, response.PullRequest.Base.Repo
+
+	// The head repository is null when the fork is deleted, so a pull request is only trusted when both are known
+	fork := head == nil || base == nil || head.FullName != base.FullName
+
+	return []PushEvent{{
+		Provider:      p.Name(),
+		Event:         "pull_request",
+		RepositoryUrl: response.Repository.HtmlUrl,
+		Ref:           fmt.Sprintf("refs/pull/%v/head", response.Number),
+		Branch:        response.PullRequest.Base.Ref,
+		Before:        response.Before,
+		After:         response.PullRequest.Head.Sha,
+		Pusher:        response.Sender.Login,
+		PullRequest:   response.Number,
+		Action:        response.Action,
+		Fork:          fork,
+	}}, nil
+}
+
+func GithubAuthorization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorize(c, GithubProvider{})
 	}
 }
+
+func ValidSignature(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+
+	mac.Write(body)
+	computedSignature := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(computedSignature), []byte(signature))
+}
@@ -8,7 +8,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -23,13 +25,18 @@ func initializeServer(t *testing.T) *http.Server {
 		c.JSON(http.StatusOK, gin.H{"message": "Webhook receive"})
 	})
 
+	listener, err := net.Listen("tcp", ":8080")
+
+	if err != nil {
+		t.Fatalf("Failed to listen %v", err)
+	}
+
 	server := &http.Server{
-		Addr:    ":8080",
 		Handler: router,
 	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			t.Errorf("Gin server error %v", err)
 		}
 	}()
@@ -52,6 +59,8 @@ func sendGithubRequest(t *testing.T, payload map[string]any, githubWebhookSecret
 		t.Errorf("Failed to create HTTP request %v", err)
 	}
 
+	req.Close = true
+
 	mac := hmac.New(sha256.New, []byte(githubWebhookSecret))
 
 	mac.Write(bytes.NewBuffer(jsonPayload).Bytes())
@@ -135,3 +144,102 @@ func TestGithubMiddlwareSecretNotFound(t *testing.T) {
 	assert.Equal(t, "X-Hub-Signature-256 is not found!", message, "API response message should return X-Hub-Signature-256 is not found!")
 	assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "API status should return 401 (unauthorized)")
 }
+
+func TestGithubProviderParseTagPush(t *testing.T) {
+	payload := `{
+		"ref": "refs/tags/v1.2.0",
+		"before": "0000000000000000000000000000000000000000",
+		"after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		"pusher": {"name": "khouwdevin"},
+		"repository": {"html_url": "https://github.com/khouwdevin/gitomatically"}
+	}`
+
+	req := httptest.NewRequest("POST", "/webhook", nil)
+	req.Header.Set("X-GitHub-Event", "push")
+
+	events, err := GithubProvider{}.Parse(req, []byte(payload))
+
+	assert.NoError(t, err, "Parse should not return an error")
+	assert.Equal(t, "v1.2.0", events[0].Tag, "Tag should be taken from the ref")
+	assert.Empty(t, events[0].Branch, "Tag push should not have a branch")
+}
+
+func TestGithubProviderParseRelease(t *testing.T) {
+	payload := `{
+		"action": "published",
+		"release": {"tag_name": "v1.2.0"},
+		"sender": {"login": "khouwdevin"},
+		"repository": {"html_url": "https://github.com/khouwdevin/gitomatically"}
+	}`
+
+	req := httptest.NewRequest("POST", "/webhook", nil)
+	req.Header.Set("X-GitHub-Event", "release")
+
+	events, err := GithubProvider{}.Parse(req, []byte(payload))
+
+	assert.NoError(t, err, "Parse should not return an error")
+	assert.Equal(t, []PushEvent{{
+		Provider:      "github",
+		Event:         "release",
+		RepositoryUrl: "https://github.com/khouwdevin/gitomatically",
+		Ref:           "refs/tags/v1.2.0",
+		Tag:           "v1.2.0",
+		Pusher:        "khouwdevin",
+	}}, events, "Parse should normalize the release payload")
+
+	_, err = GithubProvider{}.Parse(req, []byte(`{"action": "created", "release": {"tag_name": "v1.2.0"}}`))
+
+	assert.ErrorIs(t, err, ErrEventIgnored, "Parse should ignore releases that are not published")
+}
+
+func TestGithubProviderParsePullRequest(t *testing.T) {
+	payload := `{
+		"action": "synchronize",
+		"number": 123,
+		"before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+		"pull_request": {
+			"head": {"ref": "feature/login", "sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", "repo": {"full_name": "khouwdevin/gitomatically"}},
+			"base": {"ref": "main", "repo": {"full_name": "khouwdevin/gitomatically"}}
+		},
+		"sender": {"login": "khouwdevin"},
+		"repository": {"html_url": "https://github.com/khouwdevin/gitomatically"}
+	}`
+
+	req := httptest.NewRequest("POST", "/webhook", nil)
+	req.Header.Set("X-GitHub-Event", "pull_request")
+
+	events, err := GithubProvider{}.Parse(req, []byte(payload))
+
+	assert.NoError(t, err, "Parse should not return an error")
+	assert.Equal(t, []PushEvent{{
+		Provider:      "github",
+		Event:         "pull_request",
+		RepositoryUrl: "https://github.com/khouwdevin/gitomatically",
+		Ref:           "refs/pull/123/head",
+		Branch:        "main",
+		Before:        "95790bf891e76fee5e1747ab589903a6a1f80f22",
+		After:         "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		Pusher:        "khouwdevin",
+		PullRequest:   123,
+		Action:        "synchronize",
+	}}, events, "Parse should normalize the pull request payload")
+
+	fork := `{
+		"action": "opened",
+		"number": 124,
+		"pull_request": {
+			"head": {"ref": "main", "sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", "repo": {"full_name": "someone/gitomatically"}},
+			"base": {"ref": "main", "repo": {"full_name": "khouwdevin/gitomatically"}}
+		},
+		"repository": {"html_url": "https://github.com/khouwdevin/gitomatically"}
+	}`
+
+	events, err = GithubProvider{}.Parse(req, []byte(fork))
+
+	assert.NoError(t, err, "Parse should not return an error")
+	assert.True(t, events[0].Fork, "Pull request from another repository should be a fork")
+
+	_, err = GithubProvider{}.Parse(req, []byte(`{"action": "labeled", "number": 123}`))
+
+	assert.ErrorIs(t, err, ErrEventIgnored, "Parse should ignore other pull request actions")
+}
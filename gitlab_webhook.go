@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+type GitlabProject struct {
+	WebUrl string `json:"web_url"`
+}
+
+type GitlabAuthor struct {
+	Name string `json:"name"`
+}
+
+type GitlabCommit struct {
+	Id      string       `json:"id"`
+	Message string       `json:"message"`
+	Author  GitlabAuthor `json:"author"`
+}
+
+type GitlabResponse struct {
+	ObjectKind   string         `json:"object_kind"`
+	Ref          string         `json:"ref"`
+	Before       string         `json:"before"`
+	After        string         `json:"after"`
+	CheckoutSha  string         `json:"checkout_sha"`
+	UserUsername string         `json:"user_username"`
+	Project      GitlabProject  `json:"project"`
+	Commits      []GitlabCommit `json:"commits"`
+}
+
+type GitlabProvider struct{}
+
+func (GitlabProvider) Name() string {
+	return "gitlab"
+}
+
+func (GitlabProvider) Verify(r *http.Request, body []byte) error {
+	token := r.Header.Get("X-Gitlab-Token")
+
+	if token == "" {
+		return MissingHeaderError{Header: "X-Gitlab-Token"}
+	}
+
+	secret := os.Getenv("GITLAB_WEBHOOK_SECRET")
+
+	if secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (p GitlabProvider) Parse(r *http.Request, body []byte) ([]PushEvent, error) {
+	event := r.Header.Get("X-Gitlab-Event")
+
+	if event != "Push Hook" && event != "Tag Push Hook" {
+		return nil, ErrEventIgnored
+	}
+
+	var response GitlabResponse
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	// GitLab sends a null checkout_sha when the branch or tag is deleted
+	if response.CheckoutSha == "" {
+		return nil, nil
+	}
+
+	branch, _ := BranchFromRef(response.Ref)
+	tag, _ := TagFromRef(response.Ref)
+
+	pushEvent := PushEvent{
+		Provider:      p.Name(),
+		Event:         "push",
+		RepositoryUrl: response.Project.WebUrl,
+		Ref:           response.Ref,
+		Branch:        branch,
+		Tag:           tag,
+		Before:        response.Before,
+		After:         response.CheckoutSha,
+		Pusher:        response.UserUsername,
+	}
+
+	for _, commit := range response.Commits {
+		pushEvent.Commits = append(pushEvent.Commits, Commit{Id: commit.Id, Message: commit.Message, Author: commit.Author.Name})
+	}
+
+	return []PushEvent{pushEvent}, nil
+}
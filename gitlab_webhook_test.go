@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitlabProviderVerify(t *testing.T) {
+	t.Setenv("GITLAB_WEBHOOK_SECRET", "helloworld")
+
+	provider := GitlabProvider{}
+
+	req := httptest.NewRequest("POST", "/webhook/gitlab", nil)
+	req.Header.Set("X-Gitlab-Token", "helloworld")
+
+	assert.NoError(t, provider.Verify(req, nil), "Verify should accept the configured token")
+
+	req.Header.Set("X-Gitlab-Token", "worldhello")
+
+	assert.ErrorIs(t, provider.Verify(req, nil), ErrInvalidSignature, "Verify should reject a wrong token")
+
+	req.Header.Del("X-Gitlab-Token")
+
+	assert.ErrorAs(t, provider.Verify(req, nil), &MissingHeaderError{}, "Verify should reject a missing token")
+}
+
+func TestGitlabProviderSecretNotConfigured(t *testing.T) {
+	t.Setenv("GITLAB_WEBHOOK_SECRET", "")
+
+	req := httptest.NewRequest("POST", "/webhook/gitlab", nil)
+	req.Header.Set("X-Gitlab-Token", "helloworld")
+
+	assert.ErrorIs(t, GitlabProvider{}.Verify(req, nil), ErrInvalidSignature, "Verify should reject every token when the secret is not configured")
+}
+
+func TestGitlabProviderParse(t *testing.T) {
+	payload := `{
+		"object_kind": "push",
+		"ref": "refs/heads/master",
+		"before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+		"after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		"checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		"user_username": "khouwdevin",
+		"project": {"web_url": "https://gitlab.com/khouwdevin/gitomatically"},
+		"commits": [{"id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", "message": "fix: typo", "author": {"name": "Devin"}}]
+	}`
+
+	req := httptest.NewRequest("POST", "/webhook/gitlab", nil)
+	req.Header.Set("X-Gitlab-Event", "Push Hook")
+
+	events, err := GitlabProvider{}.Parse(req, []byte(payload))
+
+	assert.NoError(t, err, "Parse should not return an error")
+	assert.Equal(t, []PushEvent{{
+		Provider:      "gitlab",
+		Event:         "push",
+		RepositoryUrl: "https://gitlab.com/khouwdevin/gitomatically",
+		Ref:           "refs/heads/master",
+		Branch:        "master",
+		Before:        "95790bf891e76fee5e1747ab589903a6a1f80f22",
+		After:         "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		Pusher:        "khouwdevin",
+		Commits:       []Commit{{Id: "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", Message: "fix: typo", Author: "Devin"}},
+	}}, events, "Parse should normalize the push payload")
+}
+
+func TestGitlabProviderParseDeletedBranch(t *testing.T) {
+	payload := `{"object_kind": "push", "ref": "refs/heads/feature", "checkout_sha": null, "project": {"web_url": "https://gitlab.com/khouwdevin/gitomatically"}}`
+
+	req := httptest.NewRequest("POST", "/webhook/gitlab", nil)
+	req.Header.Set("X-Gitlab-Event", "Push Hook")
+
+	events, err := GitlabProvider{}.Parse(req, []byte(payload))
+
+	assert.NoError(t, err, "Parse should not return an error")
+	assert.Empty(t, events, "Deleted branch should not produce an event")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/khouwdevin/gitomatically/watcher"
+)
+
+// HealthController reports that the process is alive
+func HealthController(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadinessController reports whether deployments can be accepted, every failing check is returned with its reason
+func ReadinessController(c *gin.Context) {
+	checks := Readiness()
+
+	status := http.StatusOK
+
+	for name, result := range checks {
+		if result != "ok" {
+			slog.Debug(fmt.Sprintf("HEALTH Readiness check %v failed %v", name, result))
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{"status": "not ready", "checks": checks})
+		return
+	}
+
+	c.JSON(status, gin.H{"status": "ready", "checks": checks})
+}
+
+func Readiness() map[string]string {
+	return map[string]string{
+		"settings":     checkResult(checkSettings()),
+		"queue":        checkResult(checkQueue()),
+		"private_key":  checkResult(checkPrivateKey()),
+		"repositories": checkResult(checkRepositories()),
+	}
+}
+
+func checkResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	return "ok"
+}
+
+func checkSettings() error {
+	if watcher.GetSettingStatus() {
+		return errors.New("settings are changing")
+	}
+
+	return nil
+}
+
+func checkQueue() error {
+	pending, ok := PendingJobs()
+
+	if !ok {
+		return ErrQueueClosed
+	}
+
+	if pending >= Settings.Preference.QueueLimit {
+		return fmt.Errorf("%v deployments are waiting", pending)
+	}
+
+	return nil
+}
+
+func checkPrivateKey() error {
+	_, err := ssh.NewPublicKeysFromFile("git", Settings.Preference.PrivateKey, Settings.Preference.Paraphrase)
+
+	return err
+}
+
+func checkRepositories() error {
+	for _, repository := range Repositories() {
+		info, err := os.Stat(repository.Path)
+
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return fmt.Errorf("%v is not a directory", repository.Path)
+		}
+	}
+
+	return nil
+}
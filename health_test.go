@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/khouwdevin/gitomatically/watcher"
+	"github.com/stretchr/testify/assert"
+)
+
+func sendHealthRequest(t *testing.T, url string) (*httptest.ResponseRecorder, map[string]any) {
+	router := gin.New()
+	router.GET("/healthz", HealthController)
+	router.GET("/readyz", ReadinessController)
+
+	req := httptest.NewRequest("GET", url, nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	var jsonResponse map[string]any
+
+	err := json.Unmarshal(res.Body.Bytes(), &jsonResponse)
+
+	if err != nil {
+		t.Errorf("Failed to unmarshall response %v", err)
+	}
+
+	return res, jsonResponse
+}
+
+func readyTestConfig(t *testing.T) RepositoryConfig {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	Settings.Preference.Workers = 1
+	Settings.Preference.QueueLimit = 1
+	Settings.Repositories = map[string]RepositoryConfig{"gitomatically": repository}
+
+	return repository
+}
+
+func TestHealth(t *testing.T) {
+	res, jsonResponse := sendHealthRequest(t, "/healthz")
+
+	assert.Equal(t, http.StatusOK, res.Code, "Health status should return 200")
+	assert.Equal(t, "ok", jsonResponse["status"], "Health should return ok")
+}
+
+func TestReady(t *testing.T) {
+	readyTestConfig(t)
+
+	StartQueue()
+	t.Cleanup(StopQueue)
+
+	res, jsonResponse := sendHealthRequest(t, "/readyz")
+
+	assert.Equal(t, http.StatusOK, res.Code, "Ready status should return 200")
+	assert.Equal(t, "ready", jsonResponse["status"], "Ready should return ready")
+}
+
+func TestNotReadySettingsChanging(t *testing.T) {
+	readyTestConfig(t)
+
+	StartQueue()
+	t.Cleanup(StopQueue)
+
+	watcher.UpdateSettingStatus(true)
+	t.Cleanup(func() { watcher.UpdateSettingStatus(false) })
+
+	res, jsonResponse := sendHealthRequest(t, "/readyz")
+	checks := jsonResponse["checks"].(map[string]any)
+
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code, "Ready status should return 503")
+	assert.Equal(t, "settings are changing", checks["settings"], "Settings check should fail")
+}
+
+func TestNotReadyQueue(t *testing.T) {
+	readyTestConfig(t)
+
+	res, jsonResponse := sendHealthRequest(t, "/readyz")
+	checks := jsonResponse["checks"].(map[string]any)
+
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code, "Ready status should return 503 without a queue")
+	assert.Equal(t, ErrQueueClosed.Error(), checks["queue"], "Queue check should fail without a queue")
+
+	q := NewQueue(0)
+	t.Cleanup(func() { q.closed = true })
+
+	queueMutex.Lock()
+	Jobs = q
+	queueMutex.Unlock()
+
+	t.Cleanup(func() {
+		queueMutex.Lock()
+		Jobs = nil
+		queueMutex.Unlock()
+	})
+
+	q.Enqueue(NewJob(Settings.Repositories["gitomatically"], PushEvent{Branch: "master"}, "manual", DeployOptions{}))
+	t.Cleanup(func() { watcher.ControllerGroup.Done() })
+
+	_, jsonResponse = sendHealthRequest(t, "/readyz")
+	checks = jsonResponse["checks"].(map[string]any)
+
+	assert.Equal(t, "1 deployments are waiting", checks["queue"], "Queue check should fail when the queue is saturated")
+}
+
+func TestNotReadyPrivateKeyAndPath(t *testing.T) {
+	repository := readyTestConfig(t)
+
+	StartQueue()
+	t.Cleanup(StopQueue)
+
+	os.Remove(Settings.Preference.PrivateKey)
+	os.RemoveAll(repository.Path)
+
+	res, jsonResponse := sendHealthRequest(t, "/readyz")
+	checks := jsonResponse["checks"].(map[string]any)
+
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code, "Ready status should return 503")
+	assert.NotEqual(t, "ok", checks["private_key"], "Private key check should fail")
+	assert.NotEqual(t, "ok", checks["repositories"], "Repositories check should fail")
+	assert.Equal(t, "ok", checks["queue"], "Queue check should pass")
+}
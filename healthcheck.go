@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// healthcheckBodyLimit is how many bytes of the HTTP response body are matched
+const healthcheckBodyLimit = 1024 * 1024
+
+// RollbackError is returned when the deployment failed its health check and the worktree is rolled back
+type RollbackError struct {
+	// Sha is the commit that failed the health check
+	Sha string
+	Err error
+}
+
+func (e *RollbackError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// verifyDeployment runs the health check of the repository. When it fails, the worktree is reset to the commit it had
+// before the deployment and the commands are run again.
+func verifyDeployment(ctx context.Context, job *Job, repository RepositoryConfig, env []string) error {
+	if !repository.Healthcheck.Enabled() {
+		return nil
+	}
+
+	err := RunHealthcheck(ctx, job, repository, env)
+
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	checkErr := &StepError{Step: Step{Name: "healthcheck"}, Output: err.Error(), Err: fmt.Errorf("health check failed: %w", err)}
+
+	sha, _ := HeadSha(repository.Path)
+
+	if job.PreviousSha == "" || job.PreviousSha == sha {
+		job.Logs().Write("system", "Health check failed, there is no previous commit to roll back to")
+		return checkErr
+	}
+
+	slog.Warn(fmt.Sprintf("DEPLOY Health check of %v failed, rolling back to %v", job.Repository, job.PreviousSha))
+	job.Logs().Write("system", fmt.Sprintf("Health check failed, rolling back to %v", job.PreviousSha))
+
+	err = GitReset(repository, job.PreviousSha)
+
+	if err == nil {
+		err = RunCommands(ctx, job, repository, env)
+	}
+
+	if err != nil {
+		return &StepError{Step: checkErr.Step, Output: checkErr.Output, Err: fmt.Errorf("%w, rollback failed: %v", checkErr.Err, err)}
+	}
+
+	return &RollbackError{Sha: sha, Err: checkErr}
+}
+
+// RunHealthcheck checks the app until it is healthy or the check failed more often than it is retried
+func RunHealthcheck(ctx context.Context, job *Job, repository RepositoryConfig, env []string) error {
+	healthcheck := repository.Healthcheck
+	attempts := healthcheck.Retries + 1
+
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(healthcheck.Interval):
+			}
+		}
+
+		err = checkHealth(ctx, job, repository, env)
+
+		if err == nil {
+			job.Logs().Write("system", fmt.Sprintf("Health check passed, attempt %v of %v", attempt, attempts))
+			return nil
+		}
+
+		job.Logs().Write("system", fmt.Sprintf("Health check failed %v, attempt %v of %v", err, attempt, attempts))
+
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func checkHealth(ctx context.Context, job *Job, repository RepositoryConfig, env []string) error {
+	healthcheck := repository.Healthcheck
+
+	if healthcheck.Command != "" {
+		step := Step{Name: "healthcheck", Run: healthcheck.Command, Timeout: healthcheck.Timeout}
+
+		return RunSteps(ctx, job, repository, []Step{step}, env)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthcheck.Timeout)
+	defer cancel()
+
+	if healthcheck.Tcp != "" {
+		return CheckTcp(ctx, healthcheck.Tcp)
+	}
+
+	return CheckHttp(ctx, healthcheck)
+}
+
+func CheckTcp(ctx context.Context, address string) error {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func CheckHttp(ctx context.Context, healthcheck HealthcheckConfig) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", healthcheck.Url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != healthcheck.Status {
+		return fmt.Errorf("%v returned status %v instead of %v", healthcheck.Url, res.StatusCode, healthcheck.Status)
+	}
+
+	if healthcheck.Body == "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, healthcheckBodyLimit))
+
+	if err != nil {
+		return err
+	}
+
+	matched, err := regexp.Match(healthcheck.Body, body)
+
+	if err != nil {
+		return err
+	}
+
+	if !matched {
+		return fmt.Errorf("body of %v does not match %v", healthcheck.Url, healthcheck.Body)
+	}
+
+	return nil
+}
+
+// IsRolledBack reports whether the commit was rolled back in the worktree after failing its health check, so it is
+// not deployed again until a new commit is pushed or the deployment is forced
+func IsRolledBack(path string, sha string) bool {
+	if History == nil || sha == "" {
+		return false
+	}
+
+	jobs, err := History.List()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("DEPLOY Read history error %v", err))
+		return false
+	}
+
+	for _, job := range jobs {
+		if job.Status == JobRolledBack && job.Path == path && job.RolledBackFrom == sha {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckHttp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer server.Close()
+
+	err := CheckHttp(context.Background(), HealthcheckConfig{Url: server.URL, Status: 200, Body: `"status":\s*"ok"`})
+
+	assert.NoError(t, err, "Healthy response should pass")
+
+	err = CheckHttp(context.Background(), HealthcheckConfig{Url: server.URL, Status: 200, Body: "healthy"})
+
+	assert.Error(t, err, "Body that does not match should fail")
+
+	err = CheckHttp(context.Background(), HealthcheckConfig{Url: server.URL + "/down", Status: 200})
+
+	assert.Error(t, err, "Unexpected status should fail")
+}
+
+func TestCheckTcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Error listening %v", err)
+	}
+
+	address := listener.Addr().String()
+
+	err = CheckTcp(context.Background(), address)
+
+	assert.NoError(t, err, "Open port should pass")
+
+	listener.Close()
+
+	err = CheckTcp(context.Background(), address)
+
+	assert.Error(t, err, "Closed port should fail")
+}
+
+func TestHealthcheckRollback(t *testing.T) {
+	journal, err := NewJournal(filepath.Join(t.TempDir(), "deployments.jsonl"), 100)
+
+	if err != nil {
+		t.Fatalf("New journal error %v", err)
+	}
+
+	History = journal
+	t.Cleanup(func() { History = nil })
+
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Name = "gitomatically"
+	repository.Commands = []Step{{Run: "touch deployed.txt"}}
+	repository.Healthcheck = HealthcheckConfig{Command: "test ! -f broken.go", Retries: 1, Interval: 10 * time.Millisecond, Timeout: 5 * time.Second}
+
+	err = GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	previous := headHash(t, repository.Path)
+	broken := remote.Commit("broken.go")
+
+	job := NewJob(repository, PushEvent{Branch: "master"}, "cron", DeployOptions{})
+	job.execute(context.Background())
+
+	assert.Equal(t, JobRolledBack, job.Status, "Deployment should be rolled back")
+	assert.Equal(t, broken.String(), job.RolledBackFrom, "Deployment should record the commit that failed")
+	assert.Equal(t, previous.String(), job.Sha, "Deployment should end on the previous commit")
+	assert.Equal(t, previous, headHash(t, repository.Path), "Worktree should be reset to the previous commit")
+	assert.NoFileExists(t, filepath.Join(repository.Path, "broken.go"), "Files of the failed commit should be removed")
+
+	job = NewJob(repository, PushEvent{Branch: "master"}, "cron", DeployOptions{})
+	job.execute(context.Background())
+
+	assert.Equal(t, JobUpToDate, job.Status, "Rolled back commit should not be deployed again")
+
+	fixed := remote.Commit("fixed.go")
+
+	// Only the latest commit of the branch is checked, so the broken file is still there
+	job = NewJob(repository, PushEvent{Branch: "master"}, "cron", DeployOptions{})
+	job.execute(context.Background())
+
+	assert.Equal(t, JobRolledBack, job.Status, "New commit failing the health check should be rolled back")
+	assert.Equal(t, fixed.String(), job.RolledBackFrom, "Deployment should record the new commit")
+}
+
+func TestHealthcheckWithoutPreviousCommit(t *testing.T) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Healthcheck = HealthcheckConfig{Command: "false", Interval: 10 * time.Millisecond, Timeout: 5 * time.Second}
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	job := NewJob(repository, PushEvent{Branch: "master"}, "manual", DeployOptions{SkipPull: true})
+	job.execute(context.Background())
+
+	assert.Equal(t, JobFailed, job.Status, "Deployment should fail when there is nothing to roll back to")
+	assert.Contains(t, job.Error, "health check failed", "Error should be the failed health check")
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Journal is an append only history of deployments stored as JSON lines
+type Journal struct {
+	mutex     sync.Mutex
+	path      string
+	retention int
+	count     int
+}
+
+var (
+	History *Journal
+)
+
+func InitializeJournal() error {
+	journal, err := NewJournal(filepath.Join(Settings.Preference.DataDir, "deployments.jsonl"), Settings.Preference.DeploymentHistory)
+
+	if err != nil {
+		return err
+	}
+
+	History = journal
+
+	return nil
+}
+
+func NewJournal(path string, retention int) (*Journal, error) {
+	journal := &Journal{
+		path:      path,
+		retention: retention,
+	}
+
+	jobs, err := journal.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	journal.count = len(jobs)
+
+	err = journal.terminate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return journal, nil
+}
+
+// RecordJob saves the current state of the job to the history
+func RecordJob(job *Job) {
+	if History == nil {
+		return
+	}
+
+	err := History.Append(job.Snapshot())
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("JOURNAL Record job %v error %v", job.Id, err))
+	}
+}
+
+func (j *Journal) Append(job Job) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	data, err := json.Marshal(job)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(j.path), 0755)
+
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(data, '\n'))
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	j.count++
+
+	// The file is compacted once it holds twice the retention so it is not rewritten on every deployment
+	if j.count >= j.retention*2 {
+		return j.compact()
+	}
+
+	return nil
+}
+
+// List returns the latest state of the recorded deployments from the oldest to the newest
+func (j *Journal) List() ([]Job, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	jobs, err := j.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs[max(0, len(jobs)-j.retention):], nil
+}
+
+func (j *Journal) read() ([]Job, error) {
+	file, err := os.Open(j.path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var jobs []Job
+
+	// A deployment is recorded when it starts and when it finishes, the latest record replaces the previous ones
+	positions := map[string]int{}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+
+		if len(line) == 0 {
+			continue
+		}
+
+		var job Job
+
+		// A line cut by a crash is skipped instead of making the whole history unreadable
+		if err := json.Unmarshal(line, &job); err != nil {
+			slog.Warn(fmt.Sprintf("JOURNAL Skip invalid line of %v %v", j.path, err))
+			continue
+		}
+
+		if position, ok := positions[job.Id]; ok {
+			jobs[position] = job
+			continue
+		}
+
+		positions[job.Id] = len(jobs)
+		jobs = append(jobs, job)
+	}
+
+	return jobs, scanner.Err()
+}
+
+// terminate ends a line cut by a crash, so the next deployment is not appended to it
+func (j *Journal) terminate() error {
+	data, err := os.ReadFile(j.path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = file.Write([]byte{'\n'})
+
+	return err
+}
+
+func (j *Journal) compact() error {
+	jobs, err := j.read()
+
+	if err != nil {
+		return err
+	}
+
+	jobs = jobs[max(0, len(jobs)-j.retention):]
+
+	var buffer bytes.Buffer
+
+	for _, job := range jobs {
+		data, err := json.Marshal(job)
+
+		if err != nil {
+			return err
+		}
+
+		buffer.Write(append(data, '\n'))
+	}
+
+	tempPath := fmt.Sprintf("%v.tmp", j.path)
+
+	err = os.WriteFile(tempPath, buffer.Bytes(), 0644)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tempPath, j.path)
+
+	if err != nil {
+		return err
+	}
+
+	j.count = len(jobs)
+
+	slog.Debug(fmt.Sprintf("JOURNAL Compacted %v to %v deployments", j.path, len(jobs)))
+
+	return nil
+}
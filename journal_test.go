@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "deployments.jsonl")
+
+	journal, err := NewJournal(path, 3)
+
+	assert.NoError(t, err, "New journal should not return an error")
+
+	for i := range 5 {
+		err = journal.Append(Job{Id: fmt.Sprintf("job-%v", i), Repository: "gitomatically", Status: JobSucceeded})
+
+		assert.NoError(t, err, "Append should not return an error")
+	}
+
+	jobs, err := journal.List()
+
+	assert.NoError(t, err, "List should not return an error")
+	assert.Len(t, jobs, 3, "List should only return the retained deployments")
+	assert.Equal(t, "job-4", jobs[2].Id, "Newest deployment should be last")
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+
+	if err != nil {
+		t.Fatalf("Open journal error %v", err)
+	}
+
+	file.WriteString("{\"id\":\"job-5\",\"repos")
+	file.Close()
+
+	reloaded, err := NewJournal(path, 3)
+
+	assert.NoError(t, err, "Journal with a cut line should be readable")
+
+	err = reloaded.Append(Job{Id: "job-6", Repository: "gitomatically", Status: JobFailed})
+
+	assert.NoError(t, err, "Append should not return an error")
+
+	jobs, _ = reloaded.List()
+
+	assert.Equal(t, []string{"job-3", "job-4", "job-6"}, []string{jobs[0].Id, jobs[1].Id, jobs[2].Id}, "History should survive a reload")
+	assert.Equal(t, JobFailed, jobs[2].Status, "Status should be recorded")
+
+	err = reloaded.Append(Job{Id: "job-6", Repository: "gitomatically", Status: JobSucceeded})
+
+	assert.NoError(t, err, "Append should not return an error")
+
+	jobs, _ = reloaded.List()
+
+	assert.Len(t, jobs, 3, "Latest record of a deployment should replace the previous one")
+	assert.Equal(t, JobSucceeded, jobs[2].Status, "Latest status should be returned")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+type LogLine struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// LogBuffer keeps the latest output lines of a job and wakes up the readers when a line is written
+type LogBuffer struct {
+	mutex  sync.Mutex
+	lines  []LogLine
+	first  int
+	closed bool
+	notify chan struct{}
+}
+
+// logBufferLimit is how many lines of a job are kept for streaming
+const logBufferLimit = 10000
+
+func NewLogBuffer() *LogBuffer {
+	return &LogBuffer{notify: make(chan struct{})}
+}
+
+func (b *LogBuffer) Write(stream string, text string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lines = append(b.lines, LogLine{Time: time.Now(), Stream: stream, Text: text})
+
+	if len(b.lines) > logBufferLimit {
+		b.lines = b.lines[len(b.lines)-logBufferLimit:]
+		b.first++
+	}
+
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// Close marks the end of the output, readers stop waiting once they read every line
+func (b *LogBuffer) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.closed = true
+
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// Since returns the lines from the position, the position of the next line, a channel closed on the next write and
+// whether the buffer is closed. Lines that were dropped because of the limit are skipped.
+func (b *LogBuffer) Since(position int) ([]LogLine, int, <-chan struct{}, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	start := max(position-b.first, 0)
+
+	if start > len(b.lines) {
+		start = len(b.lines)
+	}
+
+	lines := append([]LogLine(nil), b.lines[start:]...)
+
+	return lines, b.first + len(b.lines), b.notify, b.closed
+}
+
+// streamWriter splits the output of a command into lines for the log buffer and keeps the end of the whole output
+type streamWriter struct {
+	stream  string
+	logs    *LogBuffer
+	output  *commandOutput
+	partial []byte
+}
+
+type commandOutput struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (o *commandOutput) Write(p []byte) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.buffer.Write(p)
+
+	if o.buffer.Len() > commandOutputLimit {
+		o.buffer.Next(o.buffer.Len() - commandOutputLimit)
+	}
+}
+
+func (o *commandOutput) String() string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.buffer.String()
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.output.Write(p)
+
+	w.partial = append(w.partial, p...)
+
+	for {
+		index := bytes.IndexByte(w.partial, '\n')
+
+		if index == -1 {
+			break
+		}
+
+		w.logs.Write(w.stream, string(bytes.TrimRight(w.partial[:index], "\r")))
+		w.partial = w.partial[index+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the last line of the output when it does not end with a new line
+func (w *streamWriter) Flush() {
+	if len(w.partial) == 0 {
+		return
+	}
+
+	w.logs.Write(w.stream, string(w.partial))
+	w.partial = nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogBuffer(t *testing.T) {
+	logs := NewLogBuffer()
+
+	lines, next, wait, closed := logs.Since(0)
+
+	assert.Empty(t, lines, "New buffer should be empty")
+	assert.Equal(t, 0, next)
+	assert.False(t, closed, "New buffer should be open")
+
+	logs.Write("stdout", "hello")
+
+	select {
+	case <-wait:
+	default:
+		t.Error("Write should wake up the readers")
+	}
+
+	logs.Write("stderr", "world")
+
+	lines, next, _, _ = logs.Since(1)
+
+	assert.Equal(t, []string{"world"}, []string{lines[0].Text}, "Since should return the lines from the position")
+	assert.Equal(t, "stderr", lines[0].Stream)
+	assert.Equal(t, 2, next)
+
+	logs.Close()
+
+	_, _, _, closed = logs.Since(next)
+
+	assert.True(t, closed, "Closed buffer should be reported")
+}
+
+func TestLogBufferLimit(t *testing.T) {
+	logs := NewLogBuffer()
+
+	for range logBufferLimit + 5 {
+		logs.Write("stdout", "line")
+	}
+
+	lines, next, _, _ := logs.Since(0)
+
+	assert.Len(t, lines, logBufferLimit, "Buffer should only keep the latest lines")
+	assert.Equal(t, logBufferLimit+5, next, "Position should count the dropped lines")
+}
+
+func TestStreamWriter(t *testing.T) {
+	logs := NewLogBuffer()
+	output := &commandOutput{}
+	writer := &streamWriter{stream: "stdout", logs: logs, output: output}
+
+	writer.Write([]byte("first line\r\nsecond "))
+	writer.Write([]byte("line\nlast"))
+	writer.Flush()
+
+	lines, _, _, _ := logs.Since(0)
+
+	assert.Equal(t, []string{"first line", "second line", "last"}, []string{lines[0].Text, lines[1].Text, lines[2].Text}, "Output should be split by lines")
+	assert.Equal(t, "first line\r\nsecond line\nlast", output.String(), "Whole output should be kept")
+}
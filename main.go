@@ -52,13 +52,36 @@ func main() {
 		return
 	}
 
-	err = PreStart()
+	err = InitializeDeliveries()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("MAIN Initialize deliveries error %v", err))
+		return
+	}
+
+	err = InitializeJournal()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("MAIN Initialize journal error %v", err))
+		return
+	}
+
+	held, err := RecoverInterrupted()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("MAIN Recover interrupted deployments error %v", err))
+		return
+	}
+
+	err = PreStart(held...)
 
 	if err != nil {
 		slog.Error(fmt.Sprintf("MAIN Prestart error %v", err))
 		return
 	}
 
+	StartQueue()
+
 	if !Settings.Preference.Cron && os.Getenv("GITHUB_WEBHOOK_SECRET") == "" {
 		slog.Error("MAIN Github webhook secret is required")
 		return
@@ -83,7 +106,14 @@ func main() {
 	envWatcher.Run(EnvDebouncedEvents)
 	configWatcher.Run(ConfigDebouncedEvents)
 
-	// Start server or cron
+	// Start server and cron
+
+	err = NewServer()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("MAIN Server error %v", err))
+		return
+	}
 
 	if Settings.Preference.Cron {
 		err := NewCron()
@@ -92,33 +122,26 @@ func main() {
 			slog.Error(fmt.Sprintf("MAIN Create new cron error %v", err))
 			return
 		}
-	} else {
-		err := NewServer()
-
-		if err != nil {
-			slog.Error(fmt.Sprintf("MAIN Server error %v", err))
-			return
-		}
 	}
 
 	<-quit
 
 	// Quit application
 
-	if Settings.Preference.Cron {
-		err = StopCron()
+	err = StopCron()
 
-		if err != nil {
-			slog.Error(fmt.Sprintf("MAIN Stopping cron error %v", err))
-		}
-	} else {
-		err = ShutdownServer()
+	if err != nil {
+		slog.Error(fmt.Sprintf("MAIN Stopping cron error %v", err))
+	}
 
-		if err != nil {
-			slog.Error(fmt.Sprintf("MAIN Shutdown server error %v", err))
-		}
+	err = ShutdownServer()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("MAIN Shutdown server error %v", err))
 	}
 
+	StopQueue()
+
 	configWatcher.Stop()
 	envWatcher.Stop()
 
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metric is a metric family in the Prometheus text format, every combination of label values is a series
+type Metric struct {
+	mutex   sync.Mutex
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var (
+	metricsMutex sync.RWMutex
+	metrics      []*Metric
+
+	durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+	WebhookDeliveries    = NewCounter("gitomatically_webhook_deliveries_total", "Webhook deliveries by provider, event and result.", "provider", "event", "result")
+	SignatureFailures    = NewCounter("gitomatically_webhook_signature_failures_total", "Webhook deliveries rejected because of a missing or invalid signature.", "provider")
+	Deployments          = NewCounter("gitomatically_deployments_total", "Finished deployments by repository and status.", "repository", "status")
+	GitDuration          = NewHistogram("gitomatically_git_duration_seconds", "Duration of git operations.", durationBuckets, "repository", "operation")
+	CommandDuration      = NewHistogram("gitomatically_command_duration_seconds", "Duration of deployment commands.", durationBuckets, "repository", "command")
+	CronTicks            = NewCounter("gitomatically_cron_ticks_total", "Cron ticks.")
+	ConfigReloads        = NewCounter("gitomatically_config_reloads_total", "Config reloads.")
+	ConfigReloadFailures = NewCounter("gitomatically_config_reload_failures_total", "Config reloads that failed.")
+	LastSuccessfulDeploy = NewGauge("gitomatically_last_successful_deploy_timestamp_seconds", "Unix time of the last successful deployment by repository.", "repository")
+)
+
+func newMetric(name string, help string, kind string, buckets []float64, labels []string) *Metric {
+	return &Metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+}
+
+// register adds the metric to the metrics endpoint
+func register(metric *Metric) *Metric {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	metrics = append(metrics, metric)
+
+	return metric
+}
+
+func NewCounter(name string, help string, labels ...string) *Metric {
+	return register(newMetric(name, help, "counter", nil, labels))
+}
+
+func NewGauge(name string, help string, labels ...string) *Metric {
+	return register(newMetric(name, help, "gauge", nil, labels))
+}
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Metric {
+	return register(newMetric(name, help, "histogram", buckets, labels))
+}
+
+// get returns the series of the label values, the caller must hold the mutex
+func (m *Metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("%v expects %v label values, got %v", m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	s, ok := m.series[key]
+
+	if !ok {
+		s = &series{values: slices.Clone(values), counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+
+	return s
+}
+
+func (m *Metric) Inc(values ...string) {
+	m.Add(1, values...)
+}
+
+func (m *Metric) Add(value float64, values ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.get(values).value += value
+}
+
+func (m *Metric) Set(value float64, values ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.get(values).value = value
+}
+
+func (m *Metric) Observe(value float64, values ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s := m.get(values)
+
+	for i, bucket := range m.buckets {
+		if value <= bucket {
+			s.counts[i]++
+		}
+	}
+
+	s.sum += value
+	s.count++
+}
+
+// Value returns the value of a counter or gauge series, or the count of a histogram series
+func (m *Metric) Value(values ...string) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, ok := m.series[strings.Join(values, "\xff")]
+
+	if !ok {
+		return 0
+	}
+
+	if m.kind == "histogram" {
+		return float64(s.count)
+	}
+
+	return s.value
+}
+
+func (m *Metric) write(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %v %v\n", m.name, m.kind)
+
+	// Metrics without labels are reported as zero before the first event
+	if len(m.labels) == 0 {
+		m.get(nil)
+	}
+
+	keys := make([]string, 0, len(m.series))
+
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%v%v %v\n", m.name, formatLabels(m.labels, s.values), formatValue(s.value))
+			continue
+		}
+
+		for i, bucket := range m.buckets {
+			fmt.Fprintf(w, "%v_bucket%v %v\n", m.name, formatLabels(slices.Concat(m.labels, []string{"le"}), slices.Concat(s.values, []string{formatValue(bucket)})), s.counts[i])
+		}
+
+		fmt.Fprintf(w, "%v_bucket%v %v\n", m.name, formatLabels(slices.Concat(m.labels, []string{"le"}), slices.Concat(s.values, []string{"+Inf"})), s.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", m.name, formatLabels(m.labels, s.values), formatValue(s.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", m.name, formatLabels(m.labels, s.values), s.count)
+	}
+}
+
+func formatLabels(labels []string, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(labels))
+
+	for i, label := range labels {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i])
+		pairs[i] = fmt.Sprintf(`%v="%v"`, label, value)
+	}
+
+	return fmt.Sprintf("{%v}", strings.Join(pairs, ","))
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// ObserveDuration records the seconds since start, it is meant to be deferred
+func ObserveDuration(metric *Metric, start time.Time, values ...string) {
+	metric.Observe(time.Since(start).Seconds(), values...)
+}
+
+func WriteMetrics(w io.Writer) {
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+
+	for _, metric := range metrics {
+		metric.write(w)
+	}
+}
+
+func MetricsController(c *gin.Context) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	WriteMetrics(c.Writer)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsCounter(t *testing.T) {
+	counter := newMetric("test_total", "Test counter.", "counter", nil, []string{"name"})
+
+	counter.Inc("b")
+	counter.Add(2, "a\"\n")
+	counter.Inc("b")
+
+	var buffer bytes.Buffer
+	counter.write(&buffer)
+
+	expected := "# HELP test_total Test counter.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{name=\"a\\\"\\n\"} 2\n" +
+		"test_total{name=\"b\"} 2\n"
+
+	assert.Equal(t, expected, buffer.String(), "Counter should be written sorted with escaped labels")
+	assert.Equal(t, float64(2), counter.Value("b"), "Counter value should be the sum of its increments")
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	histogram := newMetric("test_seconds", "Test histogram.", "histogram", []float64{1, 5}, nil)
+
+	histogram.Observe(0.5)
+	histogram.Observe(3)
+	histogram.Observe(10)
+
+	var buffer bytes.Buffer
+	histogram.write(&buffer)
+
+	expected := "# HELP test_seconds Test histogram.\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{le=\"1\"} 1\n" +
+		"test_seconds_bucket{le=\"5\"} 2\n" +
+		"test_seconds_bucket{le=\"+Inf\"} 3\n" +
+		"test_seconds_sum 13.5\n" +
+		"test_seconds_count 3\n"
+
+	assert.Equal(t, expected, buffer.String(), "Histogram buckets should be cumulative")
+}
+
+func TestMetricsController(t *testing.T) {
+	router := gin.New()
+	router.GET("/metrics", MetricsController)
+
+	CronTicks.Inc()
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	assert.Equal(t, http.StatusOK, res.Code, "Metrics status should return 200")
+	assert.Contains(t, res.Header().Get("Content-Type"), "text/plain", "Metrics should use the Prometheus text format")
+	assert.Contains(t, res.Body.String(), "# TYPE gitomatically_cron_ticks_total counter", "Metrics should contain the cron ticks")
+	assert.Contains(t, res.Body.String(), "# TYPE gitomatically_git_duration_seconds histogram", "Metrics should contain the git duration")
+}
+
+func TestMetricsSignatureFailure(t *testing.T) {
+	t.Cleanup(func() {
+		providerMutex.Lock()
+		delete(providers, "fake")
+		providerMutex.Unlock()
+	})
+
+	RegisterProvider(fakeProvider{})
+
+	failures := SignatureFailures.Value("fake")
+	deliveries := WebhookDeliveries.Value("fake", "unknown", "unauthorized")
+
+	sendProviderRequest(t, "/webhook/fake", map[string]string{"X-Fake-Token": "worldhello", "X-Fake-Event": "push"})
+
+	assert.Equal(t, failures+1, SignatureFailures.Value("fake"), "Invalid signature should be counted")
+	assert.Equal(t, deliveries+1, WebhookDeliveries.Value("fake", "unknown", "unauthorized"), "Unauthorized delivery should be counted")
+}
+
+func TestMetricsWebhookDelivery(t *testing.T) {
+	t.Cleanup(func() {
+		providerMutex.Lock()
+		delete(providers, "fake")
+		providerMutex.Unlock()
+	})
+
+	RegisterProvider(fakeProvider{})
+
+	ignored := WebhookDeliveries.Value("fake", "unknown", "ignored")
+
+	sendProviderRequest(t, "/webhook/fake", map[string]string{"X-Fake-Token": "helloworld", "X-Fake-Event": "star"})
+
+	assert.Equal(t, ignored+1, WebhookDeliveries.Value("fake", "unknown", "ignored"), "Ignored delivery should be counted")
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// prepareCommand runs the command in its own process group, so cancelling it also stops the processes it started
+func prepareCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.WaitDelay = commandKillDelay
+
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+
+		err := syscall.Kill(-pgid, syscall.SIGTERM)
+
+		if err != nil {
+			return err
+		}
+
+		time.AfterFunc(commandKillDelay, func() {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+
+		return nil
+	}
+}
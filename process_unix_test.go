@@ -0,0 +1,97 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readPid returns the pid written to the file, it is 0 until the file is completely written
+func readPid(path string) int {
+	pidBytes, err := os.ReadFile(path)
+
+	if err != nil || !strings.HasSuffix(string(pidBytes), "\n") {
+		return 0
+	}
+
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+
+	return pid
+}
+
+// processStopped reports whether the process is gone, or on Linux a zombie that only waits to be reaped by its parent
+func processStopped(pid int) bool {
+	if errors.Is(syscall.Kill(pid, 0), syscall.ESRCH) {
+		return true
+	}
+
+	if runtime.GOOS != "linux" {
+		return false
+	}
+
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%v/stat", pid))
+
+	if err != nil {
+		return false
+	}
+
+	// The state follows the command name, which is wrapped in parentheses and can contain spaces
+	_, fields, _ := strings.Cut(string(stat), ") ")
+
+	return strings.HasPrefix(fields, "Z")
+}
+
+func TestRunCommandsCancelKillsProcessGroup(t *testing.T) {
+	dirPath := t.TempDir()
+	pidPath := filepath.Join(dirPath, "child.pid")
+
+	err := os.WriteFile(filepath.Join(dirPath, "build.sh"), []byte("sleep 30 &\necho $! > child.pid\nwait\n"), 0755)
+
+	if err != nil {
+		t.Fatalf("Write script error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancel once the child is started, so the process group is known to contain it
+	go func() {
+		for readPid(pidPath) == 0 && ctx.Err() == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		cancel()
+	}()
+
+	start := time.Now()
+
+	repository := RepositoryConfig{Path: dirPath, Commands: []Step{{Run: "sh build.sh"}, {Run: "touch after.txt"}}}
+
+	err = RunCommands(ctx, NewJob(repository, PushEvent{}, "manual", DeployOptions{}), repository, nil)
+
+	assert.ErrorIs(t, err, context.Canceled, "Cancelled command should return context canceled")
+	assert.Less(t, time.Since(start), commandKillDelay, "Cancelled command should stop before the kill delay")
+	assert.NoFileExists(t, filepath.Join(dirPath, "after.txt"), "Commands after the cancelled one should not run")
+
+	pid := readPid(pidPath)
+
+	if pid == 0 {
+		t.Fatalf("Read child pid error")
+	}
+
+	assert.Eventually(t, func() bool {
+		return processStopped(pid)
+	}, 5*time.Second, 50*time.Millisecond, "Child process of the command should be stopped")
+}
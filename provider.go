@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Commit struct {
+	Id      string `json:"id"`
+	Message string `json:"message"`
+	Author  string `json:"author"`
+}
+
+type PushEvent struct {
+	Provider string `json:"provider"`
+	Event    string `json:"event"`
+	// Repository is the name of the targeted repository when the delivery is meant for a single repository
+	Repository    string   `json:"repository"`
+	RepositoryUrl string   `json:"repository_url"`
+	Ref           string   `json:"ref"`
+	Branch        string   `json:"branch"`
+	Tag           string   `json:"tag"`
+	PullRequest   int      `json:"pull_request"`
+	Action        string   `json:"action"`
+	Before        string   `json:"before"`
+	After         string   `json:"after"`
+	Pusher        string   `json:"pusher"`
+	Commits       []Commit `json:"commits"`
+	// Sha is the exact commit to deploy, empty deploys the latest commit of the branch
+	Sha string `json:"sha"`
+	// Fork is set for pull requests opened from another repository
+	Fork bool `json:"fork"`
+}
+
+// Provider verifies and normalizes webhook deliveries of a git hosting service.
+// Parse returns ErrEventIgnored for events that should not trigger a deployment.
+type Provider interface {
+	Name() string
+	Verify(r *http.Request, body []byte) error
+	Parse(r *http.Request, body []byte) ([]PushEvent, error)
+}
+
+type MissingHeaderError struct {
+	Header string
+}
+
+func (e MissingHeaderError) Error() string {
+	return fmt.Sprintf("%v header is not found", e.Header)
+}
+
+var (
+	ErrInvalidSignature = errors.New("signature is not match")
+	ErrEventIgnored     = errors.New("event is ignored")
+
+	providers     = map[string]Provider{}
+	providerMutex sync.RWMutex
+)
+
+func init() {
+	RegisterProvider(GithubProvider{})
+	RegisterProvider(GitlabProvider{})
+	RegisterProvider(GiteaProvider{})
+	RegisterProvider(BitbucketProvider{})
+}
+
+func RegisterProvider(provider Provider) {
+	providerMutex.Lock()
+	defer providerMutex.Unlock()
+
+	providers[provider.Name()] = provider
+}
+
+func GetProvider(name string) (Provider, bool) {
+	providerMutex.RLock()
+	defer providerMutex.RUnlock()
+
+	provider, ok := providers[name]
+
+	return provider, ok
+}
+
+func ProviderNames() []string {
+	providerMutex.RLock()
+	defer providerMutex.RUnlock()
+
+	names := make([]string, 0, len(providers))
+
+	for name := range providers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func ProviderAuthorization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("provider")
+
+		if name == "" {
+			name = "github"
+		}
+
+		provider, ok := GetProvider(name)
+
+		if !ok {
+			slog.Debug(fmt.Sprintf("MIDDLEWARE Provider %v is not found", name))
+
+			c.JSON(http.StatusNotFound, gin.H{"message": "Provider is not found!"})
+			c.Abort()
+
+			return
+		}
+
+		authorize(c, provider)
+	}
+}
+
+func authorize(c *gin.Context, provider Provider) {
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("MIDDLEWARE Error reading body %v", err))
+
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		c.Abort()
+
+		return
+	}
+
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+	err = provider.Verify(c.Request, bodyBytes)
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("MIDDLEWARE %v %v", provider.Name(), err))
+
+		SignatureFailures.Inc(provider.Name())
+		WebhookDeliveries.Inc(provider.Name(), "unknown", "unauthorized")
+
+		var missingHeader MissingHeaderError
+
+		if errors.As(err, &missingHeader) {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": fmt.Sprintf("%v is not found!", missingHeader.Header)})
+		} else {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized!"})
+		}
+
+		c.Abort()
+
+		return
+	}
+
+	c.Set("provider", provider)
+	c.Next()
+}
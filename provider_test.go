@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProvider struct {
+	events []PushEvent
+}
+
+func (fakeProvider) Name() string {
+	return "fake"
+}
+
+func (fakeProvider) Verify(r *http.Request, body []byte) error {
+	if r.Header.Get("X-Fake-Token") != "helloworld" {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func (p fakeProvider) Parse(r *http.Request, body []byte) ([]PushEvent, error) {
+	if r.Header.Get("X-Fake-Event") != "push" {
+		return nil, ErrEventIgnored
+	}
+
+	return p.events, nil
+}
+
+func sendProviderRequest(t *testing.T, path string, headers map[string]string) (*httptest.ResponseRecorder, map[string]any) {
+	router := gin.New()
+	router.POST("/webhook/:provider", ProviderAuthorization(), WebhookController)
+
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString("{}"))
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	var jsonResponse map[string]any
+
+	err := json.Unmarshal(res.Body.Bytes(), &jsonResponse)
+
+	if err != nil {
+		t.Errorf("Failed to unmarshall response %v", err)
+	}
+
+	return res, jsonResponse
+}
+
+func TestRegisterProvider(t *testing.T) {
+	t.Cleanup(func() {
+		providerMutex.Lock()
+		delete(providers, "fake")
+		providerMutex.Unlock()
+	})
+
+	RegisterProvider(fakeProvider{})
+
+	provider, ok := GetProvider("fake")
+
+	assert.True(t, ok, "Registered provider should be found")
+	assert.Equal(t, "fake", provider.Name(), "Registered provider should be returned")
+	assert.Contains(t, ProviderNames(), "fake", "Provider names should contain the registered provider")
+}
+
+func TestProviderWebhookSuccess(t *testing.T) {
+	t.Cleanup(func() {
+		providerMutex.Lock()
+		delete(providers, "fake")
+		providerMutex.Unlock()
+	})
+
+	RegisterProvider(fakeProvider{})
+
+	res, jsonResponse := sendProviderRequest(t, "/webhook/fake", map[string]string{"X-Fake-Token": "helloworld", "X-Fake-Event": "push"})
+
+	assert.Equal(t, "Webhook receive", jsonResponse["message"], "Webhook response should return Webhook receive")
+	assert.Equal(t, http.StatusAccepted, res.Code, "Webhook response code should return 202")
+}
+
+func TestProviderWebhookIgnoredEvent(t *testing.T) {
+	t.Cleanup(func() {
+		providerMutex.Lock()
+		delete(providers, "fake")
+		providerMutex.Unlock()
+	})
+
+	RegisterProvider(fakeProvider{})
+
+	res, jsonResponse := sendProviderRequest(t, "/webhook/fake", map[string]string{"X-Fake-Token": "helloworld", "X-Fake-Event": "star"})
+
+	assert.Equal(t, "Event ignored", jsonResponse["message"], "Webhook response should return Event ignored")
+	assert.Equal(t, http.StatusOK, res.Code, "Webhook response code should return 200")
+}
+
+func TestProviderWebhookUnauthorized(t *testing.T) {
+	t.Cleanup(func() {
+		providerMutex.Lock()
+		delete(providers, "fake")
+		providerMutex.Unlock()
+	})
+
+	RegisterProvider(fakeProvider{})
+
+	res, jsonResponse := sendProviderRequest(t, "/webhook/fake", map[string]string{"X-Fake-Token": "worldhello", "X-Fake-Event": "push"})
+
+	assert.Equal(t, "Unauthorized!", jsonResponse["message"], "API response message should return unauthorized")
+	assert.Equal(t, http.StatusUnauthorized, res.Code, "API status should return 401 (unauthorized)")
+}
+
+func TestProviderNotFound(t *testing.T) {
+	res, jsonResponse := sendProviderRequest(t, "/webhook/unknown", map[string]string{})
+
+	assert.Equal(t, "Provider is not found!", jsonResponse["message"], "API response message should return Provider is not found!")
+	assert.Equal(t, http.StatusNotFound, res.Code, "API status should return 404 (not found)")
+}
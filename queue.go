@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/khouwdevin/gitomatically/watcher"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobUpToDate  JobStatus = "up_to_date"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+	// JobSuperseded is a queued job replaced by a newer job of the same worktree before it started
+	JobSuperseded JobStatus = "superseded"
+	// JobInterrupted is a running job whose process stopped before it finished
+	JobInterrupted JobStatus = "interrupted"
+	// JobRolledBack is a job that failed its health check and whose worktree is back on the previous commit
+	JobRolledBack JobStatus = "rolled_back"
+)
+
+type Job struct {
+	Id             string           `json:"id"`
+	Repository     string           `json:"repository"`
+	Path           string           `json:"path"`
+	Config         RepositoryConfig `json:"-"`
+	Event          PushEvent        `json:"event"`
+	Trigger        string           `json:"trigger"`
+	Kind           string           `json:"kind"`
+	Options        DeployOptions    `json:"options"`
+	Status         JobStatus        `json:"status"`
+	Error          string           `json:"error,omitempty"`
+	SupersededBy   string           `json:"superseded_by,omitempty"`
+	PreviousSha    string           `json:"previous_sha,omitempty"`
+	Sha            string           `json:"sha,omitempty"`
+	RolledBackFrom string           `json:"rolled_back_from,omitempty"`
+	Commands       []CommandResult  `json:"commands,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	StartedAt      time.Time        `json:"started_at,omitzero"`
+	FinishedAt     time.Time        `json:"finished_at,omitzero"`
+
+	cancel context.CancelFunc
+	logs   *LogBuffer
+}
+
+// NewJob creates a queued job, the kind is derived from the event
+func NewJob(repository RepositoryConfig, event PushEvent, trigger string, options DeployOptions) *Job {
+	kind := "deploy"
+
+	if event.PullRequest != 0 && event.Action == "closed" {
+		kind = "teardown"
+	} else if event.PullRequest != 0 {
+		kind = "preview"
+	} else if event.Tag != "" {
+		kind = "tag"
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	job := &Job{
+		Id:         hex.EncodeToString(id),
+		Repository: repository.Name,
+		Config:     repository,
+		Event:      event,
+		Trigger:    trigger,
+		Kind:       kind,
+		Options:    options,
+		Status:     JobQueued,
+		CreatedAt:  time.Now(),
+		logs:       NewLogBuffer(),
+	}
+
+	job.Path = job.Worktree()
+
+	return job
+}
+
+// Worktree returns the directory the job works in, jobs sharing a worktree never run at the same time
+func (j *Job) Worktree() string {
+	if j.Kind == "preview" || j.Kind == "teardown" {
+		return j.Config.PreviewDirectory(j.Event.PullRequest)
+	}
+
+	return j.Config.Path
+}
+
+// Target returns the commit the job deploys, it is empty when the job deploys the latest commit it pulls
+func (j *Job) Target() string {
+	if j.Options.Sha != "" {
+		return j.Options.Sha
+	}
+
+	return j.Event.After
+}
+
+// Supersedes reports whether the job can replace the queued job. A queued job that deploys a chosen commit or skips
+// the pull, such as a rollback from the dashboard, is only replaced by another manual deployment.
+func (j *Job) Supersedes(pending *Job) bool {
+	if pending.Options.Sha == "" && !pending.Options.SkipPull {
+		return true
+	}
+
+	return j.Trigger == "manual"
+}
+
+// Replaces reports whether the job deploys another commit than the running job. Cron ticks and triggers without a
+// commit never replace a running job, it may have pulled the same commit already and the new job would be up to date.
+func (j *Job) Replaces(running *Job) bool {
+	target := j.Target()
+
+	if j.Trigger == "cron" || target == "" || target == running.Target() {
+		return false
+	}
+
+	// A sha of a manual deployment may be abbreviated
+	sha, _ := HeadSha(running.Worktree())
+
+	return !strings.HasPrefix(sha, target)
+}
+
+// Snapshot returns a copy of the job that is safe to read while the job is running
+func (j *Job) Snapshot() Job {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	job := *j
+	job.Commands = slices.Clone(j.Commands)
+
+	return job
+}
+
+// Logs returns the output lines of the job, it is nil for jobs read from the history
+func (j *Job) Logs() *LogBuffer {
+	return j.logs
+}
+
+func (j *Job) AddCommand(result CommandResult) {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	j.Commands = append(j.Commands, result)
+}
+
+func (j *Job) run(ctx context.Context) error {
+	switch j.Kind {
+	case "tag":
+		return DeployTag(ctx, j)
+	case "preview":
+		return DeployPreview(ctx, j)
+	case "teardown":
+		return TeardownPreview(ctx, j)
+	default:
+		return DeployRepository(ctx, j)
+	}
+}
+
+// execute runs the job, records the commit before and after it and saves the result to the history
+func (j *Job) execute(ctx context.Context) {
+	previousSha, _ := HeadSha(j.Worktree())
+
+	jobMutex.Lock()
+	j.Status = JobRunning
+	j.StartedAt = time.Now()
+	j.PreviousSha = previousSha
+	jobMutex.Unlock()
+
+	// The running state is recorded first, so a deployment stopped by a crash is found on the next start
+	RecordJob(j)
+
+	slog.Info(fmt.Sprintf("QUEUE Running job %v %v of %v", j.Id, j.Kind, j.Repository))
+
+	j.logs.Write("system", fmt.Sprintf("Running %v of %v triggered by %v", j.Kind, j.Repository, j.Trigger))
+
+	err := j.run(ctx)
+
+	sha, _ := HeadSha(j.Worktree())
+
+	var rollback *RollbackError
+
+	jobMutex.Lock()
+
+	j.Sha = sha
+	j.FinishedAt = time.Now()
+
+	if err != nil && ctx.Err() != nil {
+		j.Status = JobCancelled
+		j.Error = err.Error()
+		slog.Warn(fmt.Sprintf("QUEUE Job %v of %v is cancelled", j.Id, j.Repository))
+	} else if err == git.NoErrAlreadyUpToDate {
+		j.Status = JobUpToDate
+		slog.Debug(fmt.Sprintf("QUEUE %v is up to date", j.Config.Url))
+	} else if errors.As(err, &rollback) {
+		j.Status = JobRolledBack
+		j.Error = err.Error()
+		j.RolledBackFrom = rollback.Sha
+		slog.Error(fmt.Sprintf("QUEUE Job %v of %v is rolled back %v", j.Id, j.Repository, err))
+	} else if err != nil {
+		j.Status = JobFailed
+		j.Error = err.Error()
+		slog.Error(fmt.Sprintf("QUEUE Job %v of %v failed %v", j.Id, j.Repository, err))
+	} else {
+		j.Status = JobSucceeded
+		slog.Info(fmt.Sprintf("QUEUE Job %v of %v succeeded", j.Id, j.Repository))
+	}
+
+	jobMutex.Unlock()
+
+	RecordJob(j)
+
+	Deployments.Inc(j.Repository, string(j.Status))
+
+	if j.Status == JobSucceeded {
+		LastSuccessfulDeploy.Set(float64(j.FinishedAt.Unix()), j.Repository)
+	}
+
+	if j.Error != "" {
+		j.logs.Write("system", j.Error)
+	}
+
+	j.logs.Write("system", fmt.Sprintf("Finished with status %v", j.Status))
+	j.logs.Close()
+}
+
+type Queue struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	pending []*Job
+	running map[string]*Job
+	jobs    map[string]*Job
+	done    []string
+	closed  bool
+	workers sync.WaitGroup
+}
+
+const jobHistory = 100
+
+var (
+	ErrQueueClosed = errors.New("job queue is not running")
+
+	Jobs       *Queue
+	queueMutex sync.RWMutex
+	jobMutex   sync.RWMutex
+)
+
+func NewQueue(workers int) *Queue {
+	q := &Queue{
+		running: map[string]*Job{},
+		jobs:    map[string]*Job{},
+	}
+
+	q.cond = sync.NewCond(&q.mutex)
+
+	for range workers {
+		q.workers.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+// StartQueue replaces the job queue, the previous queue is drained before it stops
+func StartQueue() {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+
+	if Jobs != nil {
+		Jobs.Stop()
+	}
+
+	Jobs = NewQueue(Settings.Preference.Workers)
+
+	slog.Info(fmt.Sprintf("QUEUE Started %v workers", Settings.Preference.Workers))
+}
+
+// StopQueue stops the job queue on shutdown, queued jobs are cancelled so only the running deployments finish
+func StopQueue() {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+
+	if Jobs == nil {
+		return
+	}
+
+	Jobs.Shutdown()
+
+	Jobs = nil
+
+	slog.Info("QUEUE Queue is stopped")
+}
+
+func Enqueue(job *Job) error {
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+
+	if Jobs == nil {
+		return ErrQueueClosed
+	}
+
+	return Jobs.Enqueue(job)
+}
+
+func GetJob(id string) (Job, bool) {
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+
+	if Jobs == nil {
+		return Job{}, false
+	}
+
+	return Jobs.Get(id)
+}
+
+// PendingJobs returns how many jobs wait for a worker, it is false when the queue is not running
+func PendingJobs() (int, bool) {
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+
+	if Jobs == nil {
+		return 0, false
+	}
+
+	return Jobs.Pending(), true
+}
+
+// Enqueue adds the job to the queue, the controller group is held until the job finishes so config reloads wait for it.
+// A queued job of the same worktree and kind is superseded by the new job when it may be, and the running one is cancelled if the
+// repository cancels in progress deployments and the new job deploys another commit.
+func (q *Queue) Enqueue(job *Job) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	watcher.ControllerGroup.Add(1)
+
+	for i, pending := range q.pending {
+		if pending.Worktree() != job.Worktree() || pending.Kind != job.Kind || !job.Supersedes(pending) {
+			continue
+		}
+
+		job.Options.Force = job.Options.Force || pending.Options.Force
+
+		jobMutex.Lock()
+		pending.SupersededBy = job.Id
+		jobMutex.Unlock()
+
+		q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+		q.discard(pending, JobSuperseded)
+
+		slog.Info(fmt.Sprintf("QUEUE Job %v of %v is superseded by %v", pending.Id, pending.Repository, job.Id))
+
+		break
+	}
+
+	if running, ok := q.running[job.Worktree()]; ok && running.Kind == job.Kind && job.Config.CancelInProgress && job.Replaces(running) {
+		slog.Info(fmt.Sprintf("QUEUE Cancelling job %v of %v for %v", running.Id, running.Repository, job.Id))
+
+		running.cancel()
+	}
+
+	q.pending = append(q.pending, job)
+	q.jobs[job.Id] = job
+
+	slog.Debug(fmt.Sprintf("QUEUE Job %v %v of %v is queued", job.Id, job.Kind, job.Repository))
+
+	q.cond.Broadcast()
+
+	return nil
+}
+
+func (q *Queue) Pending() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.pending)
+}
+
+// Get returns a copy of a queued, running or recently finished job
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job, ok := q.jobs[id]
+
+	if !ok {
+		return Job{}, false
+	}
+
+	return job.Snapshot(), true
+}
+
+// Stop waits for the queued jobs to finish and stops the workers
+func (q *Queue) Stop() {
+	q.mutex.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+
+	q.workers.Wait()
+}
+
+// Shutdown cancels the queued jobs before they start and waits for the running jobs to finish
+func (q *Queue) Shutdown() {
+	q.mutex.Lock()
+
+	q.closed = true
+
+	for _, job := range q.pending {
+		jobMutex.Lock()
+		job.Error = "gitomatically is shutting down"
+		jobMutex.Unlock()
+
+		q.discard(job, JobCancelled)
+
+		slog.Info(fmt.Sprintf("QUEUE Job %v of %v is cancelled before it started", job.Id, job.Repository))
+	}
+
+	q.pending = nil
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+
+	q.workers.Wait()
+}
+
+// discard finishes a queued job that is removed from the pending jobs before it runs, the caller must hold the mutex
+func (q *Queue) discard(job *Job, status JobStatus) {
+	jobMutex.Lock()
+	job.Status = status
+	job.FinishedAt = time.Now()
+	jobMutex.Unlock()
+
+	q.forget(job)
+
+	RecordJob(job)
+
+	Deployments.Inc(job.Repository, string(status))
+
+	if job.Error != "" {
+		job.logs.Write("system", job.Error)
+	}
+
+	job.logs.Write("system", fmt.Sprintf("Finished with status %v", job.Status))
+	job.logs.Close()
+
+	watcher.ControllerGroup.Done()
+}
+
+// next returns the first pending job whose worktree is not used by a running job
+func (q *Queue) next() *Job {
+	for i, job := range q.pending {
+		if _, ok := q.running[job.Worktree()]; ok {
+			continue
+		}
+
+		q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+
+		return job
+	}
+
+	return nil
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for {
+		job := q.next()
+
+		if job == nil {
+			if q.closed && len(q.pending) == 0 {
+				return
+			}
+
+			q.cond.Wait()
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		q.running[job.Worktree()] = job
+		job.cancel = cancel
+
+		q.mutex.Unlock()
+
+		job.execute(ctx)
+
+		q.mutex.Lock()
+
+		cancel()
+		delete(q.running, job.Worktree())
+		q.forget(job)
+
+		watcher.ControllerGroup.Done()
+
+		q.cond.Broadcast()
+	}
+}
+
+// forget keeps only the latest finished jobs
+func (q *Queue) forget(job *Job) {
+	q.done = append(q.done, job.Id)
+
+	for len(q.done) > jobHistory {
+		delete(q.jobs, q.done[0])
+		q.done = q.done[1:]
+	}
+}
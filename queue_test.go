@@ -0,0 +1,217 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJobKind(t *testing.T) {
+	repository := RepositoryConfig{Name: "gitomatically", Path: "/tmp/gitomatically"}
+
+	assert.Equal(t, "deploy", NewJob(repository, PushEvent{Branch: "master"}, "webhook", DeployOptions{}).Kind, "Branch push should deploy")
+	assert.Equal(t, "tag", NewJob(repository, PushEvent{Tag: "v1.0.0"}, "webhook", DeployOptions{}).Kind, "Tag push should deploy the tag")
+
+	preview := NewJob(repository, PushEvent{PullRequest: 1, Action: "opened"}, "webhook", DeployOptions{})
+
+	assert.Equal(t, "preview", preview.Kind, "Opened pull request should deploy a preview")
+	assert.Equal(t, repository.PreviewDirectory(1), preview.Worktree(), "Preview should work in the preview directory")
+	assert.Equal(t, "teardown", NewJob(repository, PushEvent{PullRequest: 1, Action: "closed"}, "webhook", DeployOptions{}).Kind, "Closed pull request should teardown the preview")
+}
+
+func TestQueueNextSkipsBusyWorktree(t *testing.T) {
+	q := NewQueue(0)
+
+	first := NewJob(RepositoryConfig{Name: "first", Path: "/tmp/first"}, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+	second := NewJob(RepositoryConfig{Name: "first", Path: "/tmp/first"}, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+	other := NewJob(RepositoryConfig{Name: "other", Path: "/tmp/other"}, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+
+	q.pending = []*Job{first, second, other}
+
+	assert.Equal(t, first, q.next(), "First job should run first")
+
+	q.running[first.Worktree()] = first
+
+	assert.Equal(t, other, q.next(), "Job of another worktree should run while the worktree is busy")
+	assert.Nil(t, q.next(), "Job of a busy worktree should wait")
+
+	delete(q.running, first.Worktree())
+
+	assert.Equal(t, second, q.next(), "Job should run once the worktree is free")
+}
+
+func TestQueueRunsJobs(t *testing.T) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Name = "gitomatically"
+	repository.Commands = []Step{{Run: "touch deployed.txt"}}
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	previousHash := headHash(t, repository.Path)
+	hash := remote.Commit("main.go")
+
+	journal, err := NewJournal(filepath.Join(t.TempDir(), "deployments.jsonl"), 10)
+
+	if err != nil {
+		t.Fatalf("New journal error %v", err)
+	}
+
+	History = journal
+
+	t.Cleanup(func() {
+		History = nil
+	})
+
+	q := NewQueue(2)
+
+	deploy := NewJob(repository, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+	redeploy := NewJob(repository, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+
+	assert.NoError(t, q.Enqueue(deploy), "Enqueue should not return an error")
+
+	// Wait for the first job to start, otherwise it is superseded by the second one
+	assert.Eventually(t, func() bool {
+		job, _ := q.Get(deploy.Id)
+		return job.Status != JobQueued
+	}, 5*time.Second, 10*time.Millisecond, "Job should start")
+
+	assert.NoError(t, q.Enqueue(redeploy), "Enqueue should not return an error")
+
+	q.Stop()
+
+	assert.ErrorIs(t, q.Enqueue(NewJob(repository, PushEvent{}, "webhook", DeployOptions{})), ErrQueueClosed, "Stopped queue should not accept jobs")
+
+	job, ok := q.Get(deploy.Id)
+
+	assert.True(t, ok, "Finished job should be found")
+	assert.Equal(t, JobSucceeded, job.Status, "Job should succeed")
+	assert.Equal(t, hash, headHash(t, repository.Path), "Job should pull the new commit")
+	assert.FileExists(t, repository.Path+"/deployed.txt", "Job should run the commands")
+	assert.Equal(t, previousHash.String(), job.PreviousSha, "Job should record the commit before the deployment")
+	assert.Equal(t, hash.String(), job.Sha, "Job should record the deployed commit")
+	assert.Equal(t, "touch deployed.txt", job.Commands[0].Command, "Job should record the commands")
+	assert.Equal(t, 0, job.Commands[0].ExitCode, "Job should record the exit code of the commands")
+
+	jobs, _ := History.List()
+
+	assert.Len(t, jobs, 2, "Finished jobs should be recorded in the history")
+
+	job, _ = q.Get(redeploy.Id)
+
+	assert.Equal(t, JobUpToDate, job.Status, "Job of the same worktree should run after the first one")
+}
+
+func TestQueueCoalescesJobs(t *testing.T) {
+	q := NewQueue(1)
+
+	repository := RepositoryConfig{Name: "gitomatically", Path: filepath.Join(t.TempDir(), "gitomatically"), CancelInProgress: true}
+
+	cancelled := false
+	running := NewJob(repository, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+	running.cancel = func() { cancelled = true }
+
+	// Keep the worktree busy so the queued jobs wait
+	q.mutex.Lock()
+	q.running[running.Worktree()] = running
+	q.mutex.Unlock()
+
+	first := NewJob(repository, PushEvent{Branch: "master", After: "a"}, "webhook", DeployOptions{Force: true})
+	second := NewJob(repository, PushEvent{Branch: "master", After: "b"}, "webhook", DeployOptions{})
+
+	assert.NoError(t, q.Enqueue(first), "Enqueue should not return an error")
+	assert.NoError(t, q.Enqueue(second), "Enqueue should not return an error")
+
+	job, _ := q.Get(first.Id)
+
+	assert.Equal(t, JobSuperseded, job.Status, "Queued job should be superseded by the newer job")
+	assert.Equal(t, second.Id, job.SupersededBy, "Superseded job should point to the newer job")
+	assert.True(t, second.Options.Force, "Newer job should keep the force option of the superseded job")
+	assert.True(t, cancelled, "Running job should be cancelled when the repository cancels in progress deployments")
+	assert.Len(t, q.pending, 1, "Only the newest job should be queued")
+
+	q.mutex.Lock()
+	delete(q.running, running.Worktree())
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+
+	q.Stop()
+
+	job, _ = q.Get(second.Id)
+
+	assert.Equal(t, JobFailed, job.Status, "Newest job should run once the worktree is free")
+}
+
+func TestQueueShutdownCancelsPendingJobs(t *testing.T) {
+	q := NewQueue(0)
+
+	job := NewJob(RepositoryConfig{Name: "gitomatically", Path: filepath.Join(t.TempDir(), "gitomatically")}, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+
+	assert.NoError(t, q.Enqueue(job), "Enqueue should not return an error")
+
+	q.Shutdown()
+
+	snapshot, ok := q.Get(job.Id)
+
+	assert.True(t, ok, "Cancelled job should be found")
+	assert.Equal(t, JobCancelled, snapshot.Status, "Queued job should be cancelled on shutdown")
+	assert.Empty(t, q.pending, "No job should be queued after shutdown")
+	assert.ErrorIs(t, q.Enqueue(NewJob(RepositoryConfig{}, PushEvent{}, "webhook", DeployOptions{})), ErrQueueClosed, "Shut down queue should not accept jobs")
+}
+
+func TestJobReplacesRunningJob(t *testing.T) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	head := headHash(t, repository.Path).String()
+
+	// Full hashes, an abbreviated one could be a prefix of the head
+	runningSha, pushedSha := strings.Repeat("a", 40), strings.Repeat("b", 40)
+
+	running := NewJob(repository, PushEvent{Branch: "master", After: runningSha}, "webhook", DeployOptions{})
+
+	assert.True(t, NewJob(repository, PushEvent{Branch: "master", After: pushedSha}, "webhook", DeployOptions{}).Replaces(running), "Push of another commit should replace the running job")
+	assert.False(t, NewJob(repository, PushEvent{Branch: "master"}, "cron", DeployOptions{}).Replaces(running), "Cron tick should not replace the running job")
+	assert.False(t, NewJob(repository, PushEvent{Branch: "master", After: runningSha}, "webhook", DeployOptions{}).Replaces(running), "Push of the same commit should not replace the running job")
+	assert.False(t, NewJob(repository, PushEvent{Branch: "master", After: head}, "webhook", DeployOptions{}).Replaces(running), "Push of the pulled commit should not replace the running job")
+	assert.False(t, NewJob(repository, PushEvent{Branch: "master"}, "manual", DeployOptions{Sha: head[:7]}).Replaces(running), "Abbreviated sha of the pulled commit should not replace the running job")
+}
+
+func TestQueueKeepsPinnedJobs(t *testing.T) {
+	q := NewQueue(0)
+
+	repository := RepositoryConfig{Name: "gitomatically", Path: filepath.Join(t.TempDir(), "gitomatically")}
+
+	rollback := NewJob(repository, PushEvent{Branch: "master"}, "manual", DeployOptions{Sha: "da15608", SkipPull: true})
+	tick := NewJob(repository, PushEvent{Branch: "master"}, "cron", DeployOptions{})
+
+	assert.NoError(t, q.Enqueue(rollback), "Enqueue should not return an error")
+	assert.NoError(t, q.Enqueue(tick), "Enqueue should not return an error")
+
+	job, _ := q.Get(rollback.Id)
+
+	assert.Equal(t, JobQueued, job.Status, "Rollback should not be superseded by a cron tick")
+
+	manual := NewJob(repository, PushEvent{Branch: "master"}, "manual", DeployOptions{})
+
+	assert.NoError(t, q.Enqueue(manual), "Enqueue should not return an error")
+
+	job, _ = q.Get(rollback.Id)
+
+	assert.Equal(t, JobSuperseded, job.Status, "Rollback should be superseded by another manual deployment")
+
+	q.Shutdown()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// RecoverInterrupted marks the deployments that were running when the process stopped as interrupted and applies the
+// on_interrupt policy of their repository. It returns the worktrees that must not be pulled on startup.
+func RecoverInterrupted() ([]string, error) {
+	if History == nil {
+		return nil, nil
+	}
+
+	jobs, err := History.List()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var held []string
+
+	for _, job := range jobs {
+		if job.Status != JobRunning {
+			continue
+		}
+
+		job.Status = JobInterrupted
+		job.Error = "gitomatically stopped before the deployment finished"
+		job.FinishedAt = time.Now()
+
+		err := History.Append(job)
+
+		if err != nil {
+			return nil, err
+		}
+
+		repository, ok := Settings.Repositories[job.Repository]
+
+		if !ok {
+			slog.Error(fmt.Sprintf("RECOVER Deployment %v of %v was interrupted, the repository is not configured anymore", job.Id, job.Repository))
+			continue
+		}
+
+		repository.Name = job.Repository
+		repository = repository.ForEvent(job.Event)
+
+		if job.Kind == "preview" || job.Kind == "teardown" || repository.OnInterrupt == "alert" {
+			slog.Error(fmt.Sprintf("RECOVER Deployment %v of %v was interrupted at %v, check %v by hand", job.Id, job.Repository, job.StartedAt.Format(time.RFC3339), job.Path))
+			held = append(held, job.Path)
+
+			continue
+		}
+
+		var recovery *Job
+
+		if repository.OnInterrupt == "rollback" {
+			sha := LastGoodSha(jobs, job)
+
+			if sha == "" {
+				slog.Error(fmt.Sprintf("RECOVER Deployment %v of %v was interrupted and there is no known good commit to roll back to", job.Id, job.Repository))
+				held = append(held, job.Path)
+
+				continue
+			}
+
+			slog.Warn(fmt.Sprintf("RECOVER Deployment %v of %v was interrupted, rolling back to %v", job.Id, job.Repository, sha))
+
+			recovery = NewJob(repository, PushEvent{Branch: job.Event.Branch}, "startup", DeployOptions{Sha: sha, SkipPull: true})
+			held = append(held, job.Path)
+		} else {
+			slog.Warn(fmt.Sprintf("RECOVER Deployment %v of %v was interrupted, rerunning the commands", job.Id, job.Repository))
+
+			recovery = NewJob(repository, PushEvent{Branch: job.Event.Branch, Tag: job.Event.Tag}, "startup", DeployOptions{Force: true, SkipPull: true})
+		}
+
+		recovery.execute(context.Background())
+
+		if recovery.Status != JobSucceeded {
+			slog.Error(fmt.Sprintf("RECOVER Recovery of %v failed %v", job.Repository, recovery.Error))
+		}
+	}
+
+	return held, nil
+}
+
+// LastGoodSha returns the commit of the latest successful deployment of the worktree before the job, or the commit
+// the worktree had before the job started
+func LastGoodSha(jobs []Job, job Job) string {
+	for i := len(jobs) - 1; i >= 0; i-- {
+		if jobs[i].Path == job.Path && jobs[i].Status == JobSucceeded && jobs[i].Sha != "" && jobs[i].CreatedAt.Before(job.CreatedAt) {
+			return jobs[i].Sha
+		}
+	}
+
+	return job.PreviousSha
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func recoverTestSetup(t *testing.T, policy string) (*tempRemote, RepositoryConfig) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Commands = []Step{{Run: "touch recovered.txt"}}
+	repository.OnInterrupt = policy
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	Settings.Repositories = map[string]RepositoryConfig{"gitomatically": repository}
+	repository.Name = "gitomatically"
+
+	journal, err := NewJournal(filepath.Join(t.TempDir(), "deployments.jsonl"), 10)
+
+	if err != nil {
+		t.Fatalf("New journal error %v", err)
+	}
+
+	History = journal
+
+	t.Cleanup(func() {
+		History = nil
+	})
+
+	return remote, repository
+}
+
+func interruptedJob(repository RepositoryConfig, previousSha string) Job {
+	job := NewJob(repository, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+	job.Status = JobRunning
+	job.StartedAt = time.Now()
+	job.PreviousSha = previousSha
+
+	return *job
+}
+
+func TestRecoverInterruptedRerun(t *testing.T) {
+	_, repository := recoverTestSetup(t, "rerun")
+
+	job := interruptedJob(repository, "")
+	History.Append(job)
+
+	held, err := RecoverInterrupted()
+
+	assert.NoError(t, err, "Recover should not return an error")
+	assert.Empty(t, held, "Rerun should not hold the worktree")
+	assert.FileExists(t, filepath.Join(repository.Path, "recovered.txt"), "Commands should be rerun")
+
+	jobs, _ := History.List()
+
+	assert.Equal(t, JobInterrupted, jobs[0].Status, "Running deployment should be marked interrupted")
+	assert.Equal(t, "startup", jobs[1].Trigger, "Rerun should be recorded as a startup deployment")
+	assert.Equal(t, JobSucceeded, jobs[1].Status, "Rerun should succeed")
+}
+
+func TestRecoverInterruptedRollback(t *testing.T) {
+	remote, repository := recoverTestSetup(t, "rollback")
+
+	goodHash := headHash(t, repository.Path)
+
+	good := NewJob(repository, PushEvent{Branch: "master"}, "webhook", DeployOptions{})
+	good.Status = JobSucceeded
+	good.Sha = goodHash.String()
+	History.Append(*good)
+
+	remote.Commit("main.go")
+
+	err := GitPull(repository)
+
+	if err != nil {
+		t.Fatalf("Git pull error %v", err)
+	}
+
+	History.Append(interruptedJob(repository, goodHash.String()))
+
+	held, err := RecoverInterrupted()
+
+	assert.NoError(t, err, "Recover should not return an error")
+	assert.Equal(t, []string{repository.Path}, held, "Rollback should hold the worktree")
+	assert.Equal(t, goodHash, headHash(t, repository.Path), "Worktree should be rolled back to the last good commit")
+	assert.FileExists(t, filepath.Join(repository.Path, "recovered.txt"), "Commands should be run after the rollback")
+}
+
+func TestRecoverInterruptedAlert(t *testing.T) {
+	_, repository := recoverTestSetup(t, "alert")
+
+	History.Append(interruptedJob(repository, ""))
+
+	held, err := RecoverInterrupted()
+
+	assert.NoError(t, err, "Recover should not return an error")
+	assert.Equal(t, []string{repository.Path}, held, "Alert should hold the worktree")
+	assert.NoFileExists(t, filepath.Join(repository.Path, "recovered.txt"), "Commands should not be run")
+
+	jobs, _ := History.List()
+
+	assert.Len(t, jobs, 1, "Alert should not deploy")
+	assert.Equal(t, JobInterrupted, jobs[0].Status, "Running deployment should be marked interrupted")
+}
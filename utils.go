@@ -10,7 +10,9 @@ import (
 	"time"
 
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/khouwdevin/gitomatically/watcher"
 )
@@ -22,12 +24,9 @@ func IsNewUpdate(r *git.Repository, o *git.PullOptions) (bool, error) {
 		Force:      o.Force,
 	})
 
-	if err != nil {
-		if err == git.NoErrAlreadyUpToDate {
-			return false, err
-		} else {
-			return false, err
-		}
+	// The remote branch can be ahead of head even if there is nothing new to fetch
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return false, err
 	}
 
 	headRef, err := r.Head()
@@ -37,10 +36,11 @@ func IsNewUpdate(r *git.Repository, o *git.PullOptions) (bool, error) {
 		return false, err
 	}
 
-	remoteRef, err := r.Reference(o.ReferenceName, true)
+	remoteRefName := plumbing.NewRemoteReferenceName(o.RemoteName, o.ReferenceName.Short())
+	remoteRef, err := r.Reference(remoteRefName, true)
 
 	if err != nil {
-		slog.Debug(fmt.Sprintf("ISNEWUPDATE Error get reference name %v", o.ReferenceName))
+		slog.Debug(fmt.Sprintf("ISNEWUPDATE Error get reference name %v", remoteRefName))
 		return false, err
 	}
 
@@ -51,6 +51,64 @@ func IsNewUpdate(r *git.Repository, o *git.PullOptions) (bool, error) {
 	return false, nil
 }
 
+func CheckoutBranch(r *git.Repository, w *git.Worktree, o *git.PullOptions) error {
+	remoteRefName := plumbing.NewRemoteReferenceName(o.RemoteName, o.ReferenceName.Short())
+	remoteRef, err := r.Reference(remoteRefName, true)
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("CHECKOUTBRANCH Error get reference name %v", remoteRefName))
+		return err
+	}
+
+	_, err = r.Reference(o.ReferenceName, false)
+
+	if err == plumbing.ErrReferenceNotFound {
+		return w.Checkout(&git.CheckoutOptions{Branch: o.ReferenceName, Hash: remoteRef.Hash(), Create: true, Force: true})
+	} else if err != nil {
+		return err
+	}
+
+	return w.Checkout(&git.CheckoutOptions{Branch: o.ReferenceName, Force: true})
+}
+
+// GitHead returns the head of the repository, its name is HEAD when a commit or tag is checked out
+func GitHead(repositoryPath string) (*plumbing.Reference, error) {
+	r, err := git.PlainOpen(repositoryPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Head()
+}
+
+func HeadSha(repositoryPath string) (string, error) {
+	headRef, err := GitHead(repositoryPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	return headRef.Hash().String(), nil
+}
+
+// HeadCommit returns the commit checked out in the repository
+func HeadCommit(repositoryPath string) (*object.Commit, error) {
+	r, err := git.PlainOpen(repositoryPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	headRef, err := r.Head()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.CommitObject(headRef.Hash())
+}
+
 func BackupUntrackedFiles(w *git.Worktree, repositoryPath string) (string, error) {
 	gitStatus, err := w.Status()
 
@@ -122,6 +180,8 @@ func ReturnUntrackedFiles(tempDirPath string, repositoryPath string) error {
 
 func GitClone(repository RepositoryConfig) error {
 	slog.Debug(fmt.Sprintf("GITCLONE Clone %v start", repository.Url))
+	defer ObserveDuration(GitDuration, time.Now(), repository.Name, "clone")
+
 	err := os.RemoveAll(repository.Path)
 
 	if err != nil {
@@ -145,10 +205,16 @@ func GitClone(repository RepositoryConfig) error {
 		return err
 	}
 
-	_, err = git.PlainClone(repository.Path, false, &git.CloneOptions{
+	cloneOption := &git.CloneOptions{
 		Auth: publicKeys,
 		URL:  repository.Clone,
-	})
+	}
+
+	if repository.Branch != "" && !repository.DeploysTags() {
+		cloneOption.ReferenceName = plumbing.NewBranchReferenceName(repository.Branch)
+	}
+
+	_, err = git.PlainClone(repository.Path, false, cloneOption)
 
 	slog.Debug(fmt.Sprintf("GITCLONE Error do plain clone %v", repository.Url))
 
@@ -157,6 +223,8 @@ func GitClone(repository RepositoryConfig) error {
 
 func GitPull(repository RepositoryConfig) error {
 	slog.Debug(fmt.Sprintf("GITPULL Pull %v start", repository.Url))
+	defer ObserveDuration(GitDuration, time.Now(), repository.Name, "pull")
+
 	publicKeys, err := ssh.NewPublicKeysFromFile("git", Settings.Preference.PrivateKey, Settings.Preference.Paraphrase)
 
 	if err != nil {
@@ -191,32 +259,316 @@ func GitPull(repository RepositoryConfig) error {
 		return err
 	}
 
-	if isNewUpdate {
-		tempDirPath, err := BackupUntrackedFiles(w, repository.Path)
+	if !isNewUpdate {
+		return git.NoErrAlreadyUpToDate
+	}
+
+	tempDirPath, err := BackupUntrackedFiles(w, repository.Path)
+
+	if err != nil {
+		return err
+	}
+
+	headRef, err := r.Head()
+
+	if err != nil {
+		slog.Debug("GITPULL Error get head")
+		return err
+	}
+
+	// Branch patterns can deploy several branches into the same path
+	switchedBranch := headRef.Name() != pullOption.ReferenceName
+
+	if switchedBranch {
+		slog.Debug(fmt.Sprintf("GITPULL Checkout %v from %v", pullOption.ReferenceName, headRef.Name()))
+
+		err = CheckoutBranch(r, w, pullOption)
 
 		if err != nil {
+			slog.Debug(fmt.Sprintf("GITPULL Error checkout branch %v", repository.Branch))
 			return err
 		}
+	}
+
+	err = w.Pull(pullOption)
+
+	if err != nil && !(switchedBranch && err == git.NoErrAlreadyUpToDate) {
+		slog.Debug(fmt.Sprintf("GITPULL Error pull repository %v", repository.Url))
+		return err
+	}
+
+	if len(tempDirPath) > 0 {
+		return ReturnUntrackedFiles(tempDirPath, repository.Path)
+	}
+
+	return nil
+}
+
+// GitRemoteSha fetches the branch of the repository and returns its latest commit
+func GitRemoteSha(repository RepositoryConfig) (string, error) {
+	publicKeys, err := ssh.NewPublicKeysFromFile("git", Settings.Preference.PrivateKey, Settings.Preference.Paraphrase)
+
+	if err != nil {
+		slog.Debug("GITREMOTESHA Error get public keys from file")
+		return "", err
+	}
+
+	r, err := git.PlainOpen(repository.Path)
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITREMOTESHA Error do plain open %v", repository.Path))
+		return "", err
+	}
+
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       publicKeys,
+	})
+
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		slog.Debug(fmt.Sprintf("GITREMOTESHA Error fetch %v", repository.Url))
+		return "", err
+	}
+
+	remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName("origin", repository.Branch), true)
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITREMOTESHA Error get reference of %v", repository.Branch))
+		return "", err
+	}
+
+	return remoteRef.Hash().String(), nil
+}
+
+// GitHasUpdate reports whether pulling the branch of the repository would change the worktree
+func GitHasUpdate(repository RepositoryConfig) (bool, error) {
+	remoteSha, err := GitRemoteSha(repository)
+
+	if err != nil {
+		return false, err
+	}
+
+	sha, err := HeadSha(repository.Path)
+
+	if err != nil {
+		return false, err
+	}
+
+	return sha != remoteSha, nil
+}
+
+func GitReset(repository RepositoryConfig, sha string) error {
+	slog.Debug(fmt.Sprintf("GITRESET Reset %v to %v", repository.Url, sha))
+
+	r, err := git.PlainOpen(repository.Path)
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITRESET Error do plain open %v", repository.Path))
+		return err
+	}
+
+	hash, err := r.ResolveRevision(plumbing.Revision(sha))
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITRESET Error resolve revision %v", sha))
+		return err
+	}
+
+	w, err := r.Worktree()
+
+	if err != nil {
+		slog.Debug("GITRESET Error get worktree")
+		return err
+	}
+
+	return w.Reset(&git.ResetOptions{Commit: *hash, Mode: git.HardReset})
+}
+
+func GitCheckoutTag(repository RepositoryConfig, tag string) error {
+	slog.Debug(fmt.Sprintf("GITCHECKOUTTAG Checkout %v of %v start", tag, repository.Url))
+	defer ObserveDuration(GitDuration, time.Now(), repository.Name, "fetch")
+
+	r, err := git.PlainOpen(repository.Path)
 
-		err = w.Pull(pullOption)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITCHECKOUTTAG Error do plain open %v", repository.Path))
+		return err
+	}
+
+	commitHash, err := fetchTag(r, tag)
+
+	if err != nil {
+		return err
+	}
+
+	if isDetachedAt(r, commitHash) {
+		return git.NoErrAlreadyUpToDate
+	}
+
+	w, err := r.Worktree()
+
+	if err != nil {
+		slog.Debug("GITCHECKOUTTAG Error get worktree")
+		return err
+	}
+
+	tempDirPath, err := BackupUntrackedFiles(w, repository.Path)
+
+	if err != nil {
+		return err
+	}
+
+	err = w.Checkout(&git.CheckoutOptions{Hash: commitHash, Force: true})
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITCHECKOUTTAG Error checkout %v", commitHash))
+		return err
+	}
+
+	if len(tempDirPath) > 0 {
+		return ReturnUntrackedFiles(tempDirPath, repository.Path)
+	}
+
+	return nil
+}
+
+// GitTagCheckedOut fetches the tag and reports whether it is already checked out, without changing the worktree
+func GitTagCheckedOut(repository RepositoryConfig, tag string) (bool, error) {
+	defer ObserveDuration(GitDuration, time.Now(), repository.Name, "fetch")
+
+	r, err := git.PlainOpen(repository.Path)
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITCHECKOUTTAG Error do plain open %v", repository.Path))
+		return false, err
+	}
+
+	commitHash, err := fetchTag(r, tag)
+
+	if err != nil {
+		return false, err
+	}
+
+	return isDetachedAt(r, commitHash), nil
+}
+
+// fetchTag fetches the tag from origin and returns the commit it points to
+func fetchTag(r *git.Repository, tag string) (plumbing.Hash, error) {
+	publicKeys, err := ssh.NewPublicKeysFromFile("git", Settings.Preference.PrivateKey, Settings.Preference.Paraphrase)
+
+	if err != nil {
+		slog.Debug("GITCHECKOUTTAG Error get public keys from file")
+		return plumbing.ZeroHash, err
+	}
+
+	tagRefName := plumbing.NewTagReferenceName(tag)
+
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%v:%v", tagRefName, tagRefName))},
+		Auth:       publicKeys,
+		Force:      true,
+	})
+
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		slog.Debug(fmt.Sprintf("GITCHECKOUTTAG Error fetch tag %v", tag))
+		return plumbing.ZeroHash, err
+	}
+
+	tagRef, err := r.Reference(tagRefName, true)
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITCHECKOUTTAG Error get reference %v", tagRefName))
+		return plumbing.ZeroHash, err
+	}
+
+	// Annotated tags point to a tag object instead of the commit
+	tagObject, err := r.TagObject(tagRef.Hash())
+
+	if err != nil {
+		return tagRef.Hash(), nil
+	}
+
+	commit, err := tagObject.Commit()
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITCHECKOUTTAG Error get commit of tag %v", tag))
+		return plumbing.ZeroHash, err
+	}
+
+	return commit.Hash, nil
+}
+
+// isDetachedAt reports whether the head is detached at the commit. A branch on the commit of a tag, such as the
+// default branch after the clone, has not deployed the tag yet.
+func isDetachedAt(r *git.Repository, commitHash plumbing.Hash) bool {
+	headRef, err := r.Head()
+
+	return err == nil && headRef.Name() == plumbing.HEAD && headRef.Hash() == commitHash
+}
+
+func GitCheckoutPullRequest(repository RepositoryConfig, number int, sha string) error {
+	slog.Debug(fmt.Sprintf("GITCHECKOUTPULLREQUEST Checkout pull request %v of %v start", number, repository.Url))
+	defer ObserveDuration(GitDuration, time.Now(), repository.Name, "fetch")
+
+	_, err := os.Stat(filepath.Join(repository.Path, ".git"))
+
+	if os.IsNotExist(err) {
+		err = GitClone(repository)
 
 		if err != nil {
-			slog.Debug(fmt.Sprintf("GITPULL Error pull repository %v", repository.Url))
 			return err
 		}
+	} else if err != nil {
+		return err
+	}
 
-		if len(tempDirPath) > 0 {
-			err = ReturnUntrackedFiles(tempDirPath, repository.Path)
+	publicKeys, err := ssh.NewPublicKeysFromFile("git", Settings.Preference.PrivateKey, Settings.Preference.Paraphrase)
 
-			if err != nil {
-				return err
-			}
+	if err != nil {
+		slog.Debug("GITCHECKOUTPULLREQUEST Error get public keys from file")
+		return err
+	}
+
+	r, err := git.PlainOpen(repository.Path)
+
+	if err != nil {
+		slog.Debug(fmt.Sprintf("GITCHECKOUTPULLREQUEST Error do plain open %v", repository.Path))
+		return err
+	}
+
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+refs/pull/%v/head:refs/remotes/origin/pr/%v", number, number))},
+		Auth:       publicKeys,
+		Force:      true,
+	})
+
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		slog.Debug(fmt.Sprintf("GITCHECKOUTPULLREQUEST Error fetch pull request %v", number))
+		return err
+	}
+
+	hash := plumbing.NewHash(sha)
+
+	if sha == "" {
+		ref, err := r.Reference(plumbing.NewRemoteReferenceName("origin", fmt.Sprintf("pr/%v", number)), true)
 
-			return nil
+		if err != nil {
+			return err
 		}
+
+		hash = ref.Hash()
 	}
 
-	return nil
+	w, err := r.Worktree()
+
+	if err != nil {
+		slog.Debug("GITCHECKOUTPULLREQUEST Error get worktree")
+		return err
+	}
+
+	return w.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
 }
 
 func EnvDebouncedEvents(w *watcher.Watcher) {
@@ -241,7 +593,7 @@ func EnvDebouncedEvents(w *watcher.Watcher) {
 			return
 		}
 
-		if !Settings.Preference.Cron && prevPort != currentPort {
+		if prevPort != currentPort {
 			err = NewServer()
 
 			if err != nil {
@@ -282,49 +634,74 @@ func ConfigDebouncedEvents(w *watcher.Watcher) {
 
 		if err != nil {
 			slog.Error(fmt.Sprintf("WATCHER Reinitialize config error %v", err))
+			ConfigReloadFailures.Inc()
 			w.Quit <- syscall.SIGTERM
 
 			return
 		}
 
-		err = PreStart()
+		err = InitializeDeliveries()
 
 		if err != nil {
-			slog.Error(fmt.Sprintf("WATCHER Rerun prestart error %v", err))
+			slog.Error(fmt.Sprintf("WATCHER Reinitialize deliveries error %v", err))
+			ConfigReloadFailures.Inc()
+			w.Quit <- syscall.SIGTERM
+
+			return
+		}
+
+		err = InitializeJournal()
+
+		if err != nil {
+			slog.Error(fmt.Sprintf("WATCHER Reinitialize journal error %v", err))
+			ConfigReloadFailures.Inc()
 			w.Quit <- syscall.SIGTERM
 
 			return
 		}
 
-		if prevConfig.Preference.Cron == Settings.Preference.Cron ||
-			prevConfig.Preference.Spec == Settings.Preference.Spec {
+		err = PreStart()
+
+		if err != nil {
+			slog.Error(fmt.Sprintf("WATCHER Rerun prestart error %v", err))
+			ConfigReloadFailures.Inc()
+			w.Quit <- syscall.SIGTERM
+
 			return
 		}
 
-		if Settings.Preference.Cron {
-			err := ShutdownServer()
+		StartQueue()
+
+		// The server keeps running in cron mode, only its webhook routes depend on the mode
+		if prevConfig.Preference.Cron != Settings.Preference.Cron {
+			err := NewServer()
 
 			if err != nil {
-				slog.Error(fmt.Sprintf("WATCHER Shutdown server error %v", err))
+				slog.Error(fmt.Sprintf("WATCHER Restart server error %v", err))
+				ConfigReloadFailures.Inc()
 				w.Quit <- syscall.SIGTERM
 
 				return
 			}
+		}
 
-			ChangeCron()
-		} else {
+		if !Settings.Preference.Cron {
 			StopCron()
-
-			err := NewServer()
+		} else if prevConfig.Preference.Cron != Settings.Preference.Cron ||
+			prevConfig.Preference.Spec != Settings.Preference.Spec {
+			err := ChangeCron()
 
 			if err != nil {
-				slog.Error(fmt.Sprintf("WATCHER Start server error %v", err))
+				slog.Error(fmt.Sprintf("WATCHER Change cron error %v", err))
+				ConfigReloadFailures.Inc()
 				w.Quit <- syscall.SIGTERM
 
 				return
 			}
 		}
 
+		ConfigReloads.Inc()
+
 		watcher.UpdateSettingStatus(false)
 	})
 }
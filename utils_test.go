@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+type tempRemote struct {
+	t          *testing.T
+	Path       string
+	Repository *git.Repository
+	Worktree   *git.Worktree
+}
+
+// createTempKey writes a throwaway private key, local remotes do not use it but GitPull and GitClone load it
+func createTempKey(t *testing.T) string {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Error generating key %v", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+
+	if err != nil {
+		t.Fatalf("Error marshal key %v", err)
+	}
+
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600)
+
+	if err != nil {
+		t.Fatalf("Error writing key %v", err)
+	}
+
+	return keyPath
+}
+
+func createTempRemote(t *testing.T) *tempRemote {
+	remotePath := filepath.Join(t.TempDir(), "remote")
+
+	r, err := git.PlainInit(remotePath, false)
+
+	if err != nil {
+		t.Fatalf("Error init remote %v", err)
+	}
+
+	w, err := r.Worktree()
+
+	if err != nil {
+		t.Fatalf("Error get remote worktree %v", err)
+	}
+
+	remote := &tempRemote{t: t, Path: remotePath, Repository: r, Worktree: w}
+	remote.Commit("README.md")
+
+	return remote
+}
+
+func (remote *tempRemote) Commit(fileName string) plumbing.Hash {
+	err := os.WriteFile(filepath.Join(remote.Path, fileName), []byte(fileName), 0644)
+
+	if err != nil {
+		remote.t.Fatalf("Error writing %v %v", fileName, err)
+	}
+
+	_, err = remote.Worktree.Add(fileName)
+
+	if err != nil {
+		remote.t.Fatalf("Error adding %v %v", fileName, err)
+	}
+
+	hash, err := remote.Worktree.Commit(fileName, &git.CommitOptions{
+		Author: &object.Signature{Name: "gitomatically", Email: "gitomatically@example.com", When: time.Now()},
+	})
+
+	if err != nil {
+		remote.t.Fatalf("Error commit %v %v", fileName, err)
+	}
+
+	return hash
+}
+
+func (remote *tempRemote) Checkout(branch string, create bool) {
+	err := remote.Worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: create})
+
+	if err != nil {
+		remote.t.Fatalf("Error checkout %v %v", branch, err)
+	}
+}
+
+func headHash(t *testing.T, repositoryPath string) plumbing.Hash {
+	r, err := git.PlainOpen(repositoryPath)
+
+	if err != nil {
+		t.Fatalf("Error open %v %v", repositoryPath, err)
+	}
+
+	head, err := r.Head()
+
+	if err != nil {
+		t.Fatalf("Error get head %v", err)
+	}
+
+	return head.Hash()
+}
+
+func tempRepositoryConfig(t *testing.T, remote *tempRemote) RepositoryConfig {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	Settings.Preference.PrivateKey = createTempKey(t)
+
+	return RepositoryConfig{
+		Provider: "github",
+		Url:      "https://github.com/khouwdevin/gitomatically",
+		Clone:    remote.Path,
+		Branch:   "master",
+		Path:     filepath.Join(t.TempDir(), "gitomatically"),
+	}
+}
+
+func TestGitPull(t *testing.T) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+
+	err := GitClone(repository)
+
+	assert.NoError(t, err, "Git clone should not return an error")
+
+	err = GitPull(repository)
+
+	assert.Equal(t, git.NoErrAlreadyUpToDate, err, "Git pull should return already up to date without new commits")
+
+	hash := remote.Commit("main.go")
+
+	err = GitPull(repository)
+
+	assert.NoError(t, err, "Git pull should not return an error")
+	assert.Equal(t, hash, headHash(t, repository.Path), "Head should be the new commit")
+	assert.FileExists(t, filepath.Join(repository.Path, "main.go"), "New file should be pulled")
+}
+
+func TestGitPullSwitchBranch(t *testing.T) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+
+	remote.Checkout("release/1", true)
+	firstHash := remote.Commit("first.go")
+	remote.Checkout("release/2", true)
+	secondHash := remote.Commit("second.go")
+
+	firstRelease, _ := RepositoryConfig{Clone: repository.Clone, Path: repository.Path, Branches: []BranchConfig{{Name: "release/*"}}}.ForBranch("release/1")
+	secondRelease, _ := RepositoryConfig{Clone: repository.Clone, Path: repository.Path, Branches: []BranchConfig{{Name: "release/*"}}}.ForBranch("release/2")
+
+	err := GitClone(firstRelease)
+
+	assert.NoError(t, err, "Git clone should not return an error")
+	assert.Equal(t, firstHash, headHash(t, repository.Path), "Clone should checkout the configured branch")
+
+	err = GitPull(secondRelease)
+
+	assert.NoError(t, err, "Git pull of another branch should not return an error")
+	assert.Equal(t, secondHash, headHash(t, repository.Path), "Head should be the other branch")
+
+	err = GitPull(firstRelease)
+
+	assert.NoError(t, err, "Git pull back to the first branch should not return an error")
+	assert.Equal(t, firstHash, headHash(t, repository.Path), "Head should be the first branch again")
+	assert.NoFileExists(t, filepath.Join(repository.Path, "second.go"), "Files of the other branch should be removed")
+}
+
+func TestGitCheckoutTagCurrentCommit(t *testing.T) {
+	remote := createTempRemote(t)
+	repository := tempRepositoryConfig(t, remote)
+	repository.Branch = ""
+	repository.DeployOn = "tags"
+	repository.TagPattern = "v*"
+	repository.Commands = []Step{{Run: "touch deployed.txt"}}
+
+	err := GitClone(repository)
+
+	if err != nil {
+		t.Fatalf("Git clone error %v", err)
+	}
+
+	hash := headHash(t, repository.Path)
+
+	_, err = remote.Repository.CreateTag("v1.0.0", hash, nil)
+
+	if err != nil {
+		t.Fatalf("Create tag error %v", err)
+	}
+
+	err = DeployTag(context.Background(), NewJob(repository, PushEvent{Tag: "v1.0.0"}, "webhook", DeployOptions{}))
+
+	assert.NoError(t, err, "Tag of the checked out branch commit should be deployed")
+	assert.FileExists(t, filepath.Join(repository.Path, "deployed.txt"), "Deploying the tag should run the commands")
+
+	head, err := GitHead(repository.Path)
+
+	assert.NoError(t, err, "Git head should not return an error")
+	assert.Equal(t, plumbing.HEAD, head.Name(), "Tag should be checked out as a detached head")
+	assert.Equal(t, hash, head.Hash(), "Tag should be checked out")
+
+	err = GitCheckoutTag(repository, "v1.0.0")
+
+	assert.Equal(t, git.NoErrAlreadyUpToDate, err, "Checked out tag should be up to date")
+}
@@ -4,28 +4,17 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"os/exec"
-	"reflect"
-	"strings"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/khouwdevin/gitomatically/watcher"
 )
 
-type RepositoryStruct struct {
-	HtmlUrl string `json:"html_url"`
-}
-
-type GithubResponse struct {
-	Repository RepositoryStruct `json:"repository"`
-	Ref        string           `json:"ref"`
-}
-
 var (
 	Server *http.Server
 )
@@ -35,23 +24,49 @@ func NewServer() error {
 		ShutdownServer()
 	}
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.LoggerWithFormatter(LogFormatter), gin.Recovery())
 
 	router.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "Welcome to gitomatically!"})
 	})
 
-	router.POST("/webhook", GithubAuthorization(), WebhookController)
+	router.GET("/healthz", HealthController)
+	router.GET("/readyz", ReadinessController)
+	router.GET("/metrics", MetricsController)
+
+	// Deployments are triggered by the cron instead of webhooks in cron mode
+	if !Settings.Preference.Cron {
+		router.POST("/webhook", GithubAuthorization(), WebhookController)
+		router.POST("/webhook/:provider", ProviderAuthorization(), WebhookController)
+		router.POST("/hooks/:name", GenericAuthorization(), WebhookController)
+	}
+
+	router.GET("/dashboard", DashboardController)
+	router.StaticFileFS("/dashboard/gitomatically.png", "public/gitomatically.png", http.FS(dashboardFiles))
+
+	api := router.Group("/api", APIAuthorization())
+	api.GET("/repositories", RepositoriesController)
+	api.GET("/deployments", DeploymentsController)
+	api.GET("/deployments/:id", DeploymentController)
+	api.POST("/repositories/:name/deploy", DeployController)
+
+	router.GET("/api/deployments/:id/stream", QueryTokenAuthorization(), APIAuthorization(), DeploymentStreamController)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%v", os.Getenv("PORT")))
+
+	if err != nil {
+		return err
+	}
 
 	slog.Info("MAIN Gin running")
 
 	Server = &http.Server{
-		Addr:    fmt.Sprintf(":%v", os.Getenv("PORT")),
 		Handler: router,
 	}
 
 	go func() {
-		if err := Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := Server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			slog.Error(fmt.Sprintf("Gin server error %v", err))
 		}
 	}()
@@ -81,93 +96,231 @@ func ShutdownServer() error {
 }
 
 func WebhookController(c *gin.Context) {
+	provider := c.MustGet("provider").(Provider)
+
+	// The delivery is counted with the event and result it ends with
+	event, result := "unknown", "error"
+	defer func() { WebhookDeliveries.Inc(provider.Name(), event, result) }()
+
 	if watcher.GetSettingStatus() {
+		result = "unavailable"
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "Settings are changing, try again later"})
 		return
 	}
 
 	watcher.ControllerGroup.Add(1)
+	defer watcher.ControllerGroup.Done()
+
+	bodyBytes, err := c.GetRawData()
+
+	if err != nil {
+		slog.Error(fmt.Sprintf("WEBHOOK Error reading body %v", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error"})
+		return
+	}
+
+	events, err := provider.Parse(c.Request, bodyBytes)
 
-	event := c.GetHeader("X-GitHub-Event")
+	if err != nil {
+		if err == ErrEventIgnored {
+			result = "ignored"
+			slog.Debug(fmt.Sprintf("WEBHOOK Not a push event from %v, return not continue the process", provider.Name()))
+			c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		} else {
+			result = "invalid"
+			slog.Debug(fmt.Sprintf("WEBHOOK Parse %v payload error %v", provider.Name(), err))
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid payload"})
+		}
 
-	if event != "push" {
-		slog.Debug("WEBHOOK Not a push event, return not continue the process")
 		return
 	}
 
-	var response GithubResponse
+	if len(events) > 0 {
+		event = events[0].Event
+	}
 
-	if err := c.BindJSON(&response); err != nil {
+	if len(events) == 1 && events[0].Event == "ping" {
+		result = "ping"
+		PingController(c, events[0])
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Webhook receive"})
+	keys := DeliveryKeys(provider, c.Request, bodyBytes)
+
+	if Deliveries != nil && len(keys) > 0 {
+		err = Deliveries.Add(keys)
+
+		if err == ErrDuplicateDelivery {
+			result = "duplicate"
+			slog.Warn(fmt.Sprintf("WEBHOOK Duplicate %v delivery rejected", provider.Name()))
+			c.JSON(http.StatusConflict, gin.H{"message": "Delivery is already processed"})
+			return
+		} else if err != nil {
+			slog.Error(fmt.Sprintf("WEBHOOK Save delivery error %v", err))
+		}
+	}
+
+	if Settings.Preference.SkipDeployedCommits {
+		events = slices.DeleteFunc(events, IsDeployed)
+
+		if len(events) == 0 {
+			result = "deployed"
+			slog.Debug(fmt.Sprintf("WEBHOOK Commits from %v delivery are already deployed", provider.Name()))
+			c.JSON(http.StatusOK, gin.H{"message": "Commit is already deployed"})
+			return
+		}
+	}
+
+	ids := []string{}
 
-	var currentRepo RepositoryConfig
+	for _, event := range events {
+		for _, job := range WebhookJobs(event) {
+			err = Enqueue(job)
 
-	htmlUrl := response.Repository.HtmlUrl
+			if err != nil {
+				slog.Error(fmt.Sprintf("WEBHOOK Enqueue job of %v error %v", job.Repository, err))
+				forgetDelivery(keys)
+				result = "unavailable"
+				c.JSON(http.StatusServiceUnavailable, gin.H{"message": "Deployment queue is not running"})
+				return
+			}
 
-	for _, repository := range Settings.Repositories {
-		if repository.Url == htmlUrl {
-			currentRepo = repository
-			break
+			ids = append(ids, job.Id)
 		}
 	}
 
-	if reflect.DeepEqual(currentRepo, RepositoryConfig{}) {
-		slog.Debug("WEBHOOK Current repo is empty, return not continue the process")
+	// A trigger of a single repository is explicit, so a ref it does not deploy is reported instead of ignored
+	if len(ids) == 0 && slices.ContainsFunc(events, func(event PushEvent) bool { return event.Repository != "" }) {
+		result = "unmatched"
+		slog.Debug(fmt.Sprintf("WEBHOOK %v trigger does not match a branch or tag of the repository", provider.Name()))
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"message": "Ref is not deployed by the repository"})
 		return
 	}
 
-	branch := strings.Split(response.Ref, "/")[2]
+	result = "accepted"
+	c.JSON(http.StatusAccepted, gin.H{"message": "Webhook receive", "jobs": ids})
+}
 
-	if branch != currentRepo.Branch {
-		slog.Debug(fmt.Sprintf("WEBHOOK Not the expected %v branch from response %v branch, skip pull and run commands", currentRepo.Branch, branch))
+// forgetDelivery removes a delivery that could not be queued, so the provider can redeliver it
+func forgetDelivery(keys []string) {
+	if Deliveries == nil || len(keys) == 0 {
 		return
 	}
 
-	publicKeys, err := ssh.NewPublicKeysFromFile("git", Settings.Preference.PrivateKey, Settings.Preference.Paraphrase)
+	err := Deliveries.Remove(keys)
 
 	if err != nil {
-		slog.Error(fmt.Sprintf("WEBHOOK New public keys from file error %v", err))
+		slog.Error(fmt.Sprintf("WEBHOOK Save delivery error %v", err))
 	}
+}
 
-	r, err := git.PlainOpen(currentRepo.Path)
+func PingController(c *gin.Context, event PushEvent) {
+	var names []string
 
-	w, err := r.Worktree()
+	for name, repository := range Settings.Repositories {
+		if repository.Provider == event.Provider && repository.Url == event.RepositoryUrl {
+			names = append(names, name)
+		}
+	}
 
-	if err != nil {
-		slog.Error(fmt.Sprintf("WEBHOOK Get work tree error %v", err))
+	if len(names) == 0 {
+		slog.Warn(fmt.Sprintf("WEBHOOK Ping from %v but no repository is configured for it", event.RepositoryUrl))
+
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("No repository is configured for %v", event.RepositoryUrl)})
+
+		return
 	}
 
-	err = w.Pull(&git.PullOptions{RemoteName: "origin", Auth: publicKeys, Progress: os.Stdout})
+	sort.Strings(names)
 
-	if err != nil {
-		if err == git.NoErrAlreadyUpToDate {
-			slog.Debug(fmt.Sprintf("WEBHOOK %v is up to date", currentRepo.Url))
-			return
+	repositories := []gin.H{}
+
+	for _, name := range names {
+		repository := Settings.Repositories[name]
+
+		target := gin.H{"name": name, "path": repository.Path}
+
+		if repository.DeploysTags() {
+			target["tag_pattern"] = repository.TagPattern
 		} else {
-			slog.Error(fmt.Sprintf("WEBHOOK Failed to pull branch %v", err))
-			return
+			var branches []string
+
+			for _, branchConfig := range repository.BranchConfigs() {
+				branches = append(branches, branchConfig.Name)
+			}
+
+			target["branches"] = branches
 		}
+
+		slog.Info(fmt.Sprintf("WEBHOOK Ping from %v will drive %v repository", event.RepositoryUrl, name))
+
+		repositories = append(repositories, target)
 	}
 
-	for _, command := range currentRepo.Commands {
-		slog.Debug(fmt.Sprintf("WEBHOOK Running %v", command))
+	c.JSON(http.StatusOK, gin.H{"message": "Pong", "repositories": repositories})
+}
 
-		arrCommand := strings.Split(command, " ")
+// IsDeployed reports whether every repository matching a push already has its head commit checked out
+func IsDeployed(event PushEvent) bool {
+	if event.After == "" || event.Repository != "" || event.PullRequest != 0 {
+		return false
+	}
 
-		cmd := exec.Command(arrCommand[0], arrCommand[1:]...)
-		cmd.Dir = currentRepo.Path
-		cmd.Env = os.Environ()
+	repositories := FindRepositories(event)
 
-		output, err := cmd.Output()
+	for _, repository := range repositories {
+		sha, err := HeadSha(repository.Path)
 
-		if err != nil {
-			slog.Debug(fmt.Sprintf("WEBHOOK Command err output %v", string(output)))
-			slog.Error(fmt.Sprintf("WEBHOOK Failed to run build command %v", arrCommand))
-			return
+		if err != nil || sha != event.After {
+			return false
 		}
 	}
 
-	watcher.ControllerGroup.Done()
+	return len(repositories) > 0
+}
+
+// WebhookJobs returns a job for every repository the event deploys
+func WebhookJobs(event PushEvent) []*Job {
+	if event.Branch == "" && event.Tag == "" {
+		slog.Debug(fmt.Sprintf("WEBHOOK %v is not a branch or tag, skip pull and run commands", event.Ref))
+		return nil
+	}
+
+	var repositories []RepositoryConfig
+
+	if event.Repository != "" {
+		repository, ok := Settings.Repositories[event.Repository]
+
+		if ok && repository.MatchesRef(event) {
+			repository.Name = event.Repository
+			repositories = append(repositories, repository.ForEvent(event))
+		}
+	} else {
+		repositories = FindRepositories(event)
+	}
+
+	if len(repositories) == 0 {
+		slog.Debug(fmt.Sprintf("WEBHOOK No %v repository configured for %v on %v, skip pull and run commands", event.Provider, event.RepositoryUrl, event.Ref))
+		return nil
+	}
+
+	slog.Debug(fmt.Sprintf("WEBHOOK %v pushed %v..%v to %v", event.Pusher, event.Before, event.After, event.Ref))
+
+	var jobs []*Job
+
+	for _, repository := range repositories {
+		if event.PullRequest != 0 && !repository.PreviewsEnabled() {
+			continue
+		}
+
+		if event.Fork && !repository.AllowForks {
+			slog.Warn(fmt.Sprintf("WEBHOOK Pull request %v of %v is from a fork, skip preview", event.PullRequest, repository.Name))
+			continue
+		}
+
+		// Deliveries for a single repository are explicit triggers, so they redeploy even without new commits
+		jobs = append(jobs, NewJob(repository, event, "webhook", DeployOptions{Sha: event.Sha, Force: event.Repository != ""}))
+	}
+
+	return jobs
 }
@@ -9,9 +9,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -30,6 +32,8 @@ func sendWebhookRequest(t *testing.T, payload GithubResponse, headers map[string
 		t.Errorf("Failed to create HTTP request %v", err)
 	}
 
+	req.Close = true
+
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
@@ -121,5 +125,222 @@ func TestWebhookSuccess(t *testing.T) {
 	message := jsonResponse["message"].(string)
 
 	assert.Equal(t, "Webhook receive", message, "Webhook response should return Webhook receive")
-	assert.Equal(t, http.StatusOK, res.StatusCode, "Webhook response code should return 200")
+	assert.Equal(t, http.StatusAccepted, res.StatusCode, "Webhook response code should return 202")
+}
+
+func sendPingRequest(t *testing.T, htmlUrl string) (*httptest.ResponseRecorder, map[string]any) {
+	router := gin.New()
+	router.POST("/webhook", GithubAuthorization(), WebhookController)
+
+	jsonPayload, err := json.Marshal(map[string]any{
+		"zen":        "Keep it logically awesome.",
+		"hook_id":    1,
+		"repository": map[string]any{"html_url": htmlUrl},
+	})
+
+	if err != nil {
+		t.Errorf("Error when marshal json %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/webhook", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("X-GitHub-Event", "ping")
+
+	mac := hmac.New(sha256.New, []byte("helloworld"))
+	mac.Write(jsonPayload)
+
+	req.Header.Set("X-Hub-Signature-256", fmt.Sprintf("sha256=%v", hex.EncodeToString(mac.Sum(nil))))
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+
+	var jsonResponse map[string]any
+
+	err = json.Unmarshal(res.Body.Bytes(), &jsonResponse)
+
+	if err != nil {
+		t.Errorf("Failed to unmarshall response %v", err)
+	}
+
+	return res, jsonResponse
+}
+
+func TestWebhookPing(t *testing.T) {
+	t.Setenv("GITHUB_WEBHOOK_SECRET", "helloworld")
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	Settings = defaultConfig(t.TempDir())
+	Settings.Repositories["gitomatically"] = RepositoryConfig{
+		Provider: "github",
+		Url:      "https://github.com/khouwdevin/gitomatically",
+		Branch:   "master",
+		Branches: []BranchConfig{{Name: "release/*"}},
+	}
+
+	res, jsonResponse := sendPingRequest(t, "https://github.com/khouwdevin/gitomatically")
+
+	assert.Equal(t, http.StatusOK, res.Code, "Ping response code should return 200")
+	assert.Equal(t, "Pong", jsonResponse["message"], "Ping response should return Pong")
+	assert.Equal(t, []any{map[string]any{
+		"name":     "gitomatically",
+		"path":     "",
+		"branches": []any{"master", "release/*"},
+	}}, jsonResponse["repositories"], "Ping response should list the configured repository")
+}
+
+func TestWebhookPingNotConfigured(t *testing.T) {
+	t.Setenv("GITHUB_WEBHOOK_SECRET", "helloworld")
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	Settings = defaultConfig(t.TempDir())
+
+	res, jsonResponse := sendPingRequest(t, "https://github.com/khouwdevin/unknown")
+
+	assert.Equal(t, http.StatusNotFound, res.Code, "Ping response code should return 404")
+	assert.Equal(t, "No repository is configured for https://github.com/khouwdevin/unknown", jsonResponse["message"], "Ping response should explain no repository is configured")
+}
+
+func TestWebhookDuplicateDelivery(t *testing.T) {
+	t.Setenv("GITHUB_WEBHOOK_SECRET", "helloworld")
+	t.Cleanup(func() {
+		Settings = Config{}
+		Deliveries = nil
+	})
+
+	Settings = defaultConfig(t.TempDir())
+	Settings.Preference.DataDir = t.TempDir()
+	Settings.Preference.DeliveryHistory = 10
+
+	err := InitializeDeliveries()
+
+	if err != nil {
+		t.Errorf("Initialize deliveries error %v", err)
+	}
+
+	router := gin.New()
+	router.POST("/webhook", GithubAuthorization(), WebhookController)
+
+	jsonPayload := []byte(`{"ref":"refs/heads/master","repository":{"html_url":"https://github.com/khouwdevin/unknown"}}`)
+
+	mac := hmac.New(sha256.New, []byte("helloworld"))
+	mac.Write(jsonPayload)
+	signature := fmt.Sprintf("sha256=%v", hex.EncodeToString(mac.Sum(nil)))
+
+	send := func(delivery string) int {
+		req := httptest.NewRequest("POST", "/webhook", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-GitHub-Delivery", delivery)
+		req.Header.Set("X-Hub-Signature-256", signature)
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		return res.Code
+	}
+
+	assert.Equal(t, http.StatusAccepted, send("1"), "First delivery should be accepted")
+	assert.Equal(t, http.StatusConflict, send("1"), "Retried delivery should be rejected")
+	assert.Equal(t, http.StatusConflict, send("2"), "Replayed body should be rejected")
+}
+
+func TestWebhookRetryAfterUnavailableQueue(t *testing.T) {
+	t.Setenv("GITHUB_WEBHOOK_SECRET", "helloworld")
+	t.Cleanup(func() {
+		Settings = Config{}
+		Deliveries = nil
+	})
+
+	Settings = defaultConfig(t.TempDir())
+	Settings.Preference.DataDir = t.TempDir()
+	Settings.Preference.DeliveryHistory = 10
+
+	repository := Settings.Repositories["gitomatically"]
+	repository.Provider = "github"
+	Settings.Repositories["gitomatically"] = repository
+
+	err := InitializeDeliveries()
+
+	if err != nil {
+		t.Errorf("Initialize deliveries error %v", err)
+	}
+
+	router := gin.New()
+	router.POST("/webhook", GithubAuthorization(), WebhookController)
+
+	jsonPayload := []byte(`{"ref":"refs/heads/master","repository":{"html_url":"https://github.com/khouwdevin/gitomatically"}}`)
+
+	mac := hmac.New(sha256.New, []byte("helloworld"))
+	mac.Write(jsonPayload)
+
+	for range 2 {
+		req := httptest.NewRequest("POST", "/webhook", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-GitHub-Delivery", "1")
+		req.Header.Set("X-Hub-Signature-256", fmt.Sprintf("sha256=%v", hex.EncodeToString(mac.Sum(nil))))
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, res.Code, "Delivery that was not queued should not be rejected as a duplicate")
+	}
+}
+
+func TestCronServerWithoutWebhook(t *testing.T) {
+	t.Setenv("PORT", "8080")
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	Settings.Preference.Cron = true
+
+	err := NewServer()
+
+	if err != nil {
+		t.Errorf("Creating server error %v", err)
+	}
+
+	defer ShutdownServer()
+
+	res, err := http.Post("http://localhost:8080/webhook", "application/json", bytes.NewBufferString("{}"))
+
+	if err != nil {
+		t.Fatalf("Failed to send request %v", err)
+	}
+
+	res.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, res.StatusCode, "Webhook should not be served in cron mode")
+}
+
+func TestWebhookJobsFork(t *testing.T) {
+	t.Cleanup(func() {
+		Settings = Config{}
+	})
+
+	Settings = defaultConfig(t.TempDir())
+
+	repository := Settings.Repositories["gitomatically"]
+	repository.Provider = "github"
+	repository.PreviewCommands = []Step{{Run: "true"}}
+	Settings.Repositories["gitomatically"] = repository
+
+	event := PushEvent{
+		Provider:      "github",
+		RepositoryUrl: repository.Url,
+		Ref:           "refs/pull/1/head",
+		Branch:        "master",
+		PullRequest:   1,
+		Action:        "opened",
+		Fork:          true,
+	}
+
+	assert.Empty(t, WebhookJobs(event), "Pull request from a fork should not deploy a preview")
+
+	repository.AllowForks = true
+	Settings.Repositories["gitomatically"] = repository
+
+	assert.Len(t, WebhookJobs(event), 1, "Pull request from a fork should deploy a preview when forks are allowed")
 }